@@ -15,6 +15,21 @@ func (a Authorizer) HasPermission(c broker.Call) bool {
 		ServiceTestRid().CallWithObjPayload().EndpointName(),
 		ServiceTestRid().CallV1().EndpointName(),
 		ServiceTestRid().CallExpectingFile().EndpointName(),
+		ServiceTestRid().CallSlow().EndpointName(),
+		ServiceTestRid().CallDeadline().EndpointName(),
+		ServiceTestRid().CallNestedDeadline().EndpointName(),
+		ServiceTestRid().CallHeaders().EndpointName(),
+		ServiceTestRid().CallNestedHeaders().EndpointName(),
+		ServiceTestRid().CallMiddleware().EndpointName(),
+		ServiceTestRid().CallFlaky().EndpointName(),
+		ServiceTestRid().CallStream().EndpointName(),
+		ServiceTestRid().CallInstance().EndpointName(),
+		ServiceTestRid().CallGather().EndpointName(),
+		ServiceTestRid().CallAsync().EndpointName(),
+		ServiceTestRid().CallLimited().EndpointName(),
+		ServiceTestRid().CallProgress().EndpointName(),
+		ServiceTestRid().CallExtended().EndpointName(),
+		ServiceTestRid().CallBackground().EndpointName(),
 		ServiceTestRid().RootEP().EndpointName():
 		return true
 	}
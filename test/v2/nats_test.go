@@ -2,20 +2,33 @@ package v2
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
+	"github.com/nats-io/nkeys"
 	"github.com/spike-events/spike-broker/v2/pkg/broker"
+	"github.com/spike-events/spike-broker/v2/pkg/broker/providers/memory"
 	"github.com/spike-events/spike-broker/v2/pkg/broker/providers/nats"
 	"github.com/spike-events/spike-broker/v2/pkg/rids"
 	"github.com/spike-events/spike-broker/v2/pkg/spike"
 	spikeutils "github.com/spike-events/spike-broker/v2/pkg/spike-utils"
+	"github.com/spike-events/spike-broker/v2/pkg/tracing"
+	"github.com/spike-events/spike-broker/v2/pkg/tracing/tracingtest"
 	"github.com/stretchr/testify/suite"
 	"github.com/vincent-petithory/dataurl"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type NatsTest struct {
@@ -26,12 +39,41 @@ type NatsTest struct {
 	http          spike.HttpServer
 	serviceBroker broker.Provider
 	httpBroker    broker.Provider
+	tracer        *sdktrace.TracerProvider
+	spans         *tracetest.InMemoryExporter
+
+	// memory runs the suite on the memory Provider instead of NATS
+	memory bool
+	bus    *memory.Bus
+}
+
+// newProvider builds a Provider reaching the suite brokers. The memory Provider only uses the Timeout and
+// ProviderOptions of config
+func (s *NatsTest) newProvider(config nats.Config) broker.Provider {
+	if s.memory {
+		return memory.NewMemoryProvider(memory.Config{
+			Bus:             s.bus,
+			Timeout:         config.Timeout,
+			ProviderOptions: config.ProviderOptions,
+		})
+	}
+	provider := nats.NewNatsProvider(config)
+	return provider
+}
+
+// requireNats skips the tests of the NATS transport when the suite runs on the memory Provider
+func (s *NatsTest) requireNats() {
+	if s.memory {
+		s.T().Skip("NATS transport only")
+	}
 }
 
 func (s *NatsTest) TearDownSuite() {
 	s.spike.Stop()
 	s.http.Shutdown()
+	s.httpBroker.Close()
 	s.serviceBroker.Close()
+	s.tracer.Shutdown(s.ctx)
 }
 
 func (s *NatsTest) SetupSuite() {
@@ -45,6 +87,10 @@ func (s *NatsTest) SetupSuite() {
 
 	s.ctx = context.Background()
 
+	// Keep spans in memory to check propagation
+	s.spans = tracingtest.NewInMemoryExporter()
+	s.tracer = tracing.Setup(s.spans)
+
 	// Use default logger to stderr
 	logger := log.New(os.Stderr, "test", log.LstdFlags)
 
@@ -54,16 +100,25 @@ func (s *NatsTest) SetupSuite() {
 	// Test Authorizer (validates route access)
 	authorizer := NewAuthorizer()
 
-	// Initialize NATS
-	s.serviceBroker = nats.NewNatsProvider(nats.Config{
-		LocalNats:      true,
-		LocalNatsDebug: false,
-		LocalNatsTrace: false,
-		DebugLevel:     0,
-		Logger:         logger,
-	})
+	// Initialize the brokers
+	if s.memory {
+		s.bus = memory.NewBus()
+		s.serviceBroker = s.newProvider(nats.Config{})
+	} else {
+		s.serviceBroker = nats.NewNatsProvider(nats.Config{
+			LocalNats:      true,
+			LocalNatsDebug: false,
+			LocalNatsTrace: false,
+			LocalServer: nats.ServerConfig{
+				JetStream: true,
+				StoreDir:  s.T().TempDir(),
+			},
+			DebugLevel: 0,
+			Logger:     logger,
+		})
+	}
 
-	s.httpBroker = nats.NewNatsProvider(nats.Config{
+	s.httpBroker = s.newProvider(nats.Config{
 		LocalNats: false,
 		Logger:    logger,
 	})
@@ -167,12 +222,1029 @@ func (s *NatsTest) TestExpectingFile() {
 	s.Require().Equal("image/webp", f.ContentType(), "invalid returned type")
 }
 
+func (s *NatsTest) TestRequestCtxDeadline() {
+	ctx, cancel := context.WithTimeout(s.ctx, 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.httpBroker.RequestCtx(ctx, ServiceTestRid().CallSlow(), nil, nil, []byte("token-string"))
+	s.Require().NotNil(err, "should have timed out")
+	s.Require().Equal(http.StatusRequestTimeout, err.Code(), "incorrect error")
+	s.Require().Less(time.Since(start), 400*time.Millisecond, "should not wait for the handler")
+}
+
+func (s *NatsTest) TestControlFrames() {
+	provider := s.newProvider(nats.Config{
+		Logger:  log.New(os.Stderr, "test", log.LstdFlags),
+		Timeout: 100 * time.Millisecond,
+	})
+	defer provider.Close()
+
+	// The handler runs past the Provider timeout after extending it
+	var steps []int
+	ctx := broker.WithProgress(s.ctx, func(data broker.RawData) {
+		var step int
+		s.Require().Nil(json.Unmarshal(data, &step))
+		steps = append(steps, step)
+	})
+	var result map[string]string
+	err := provider.RequestCtx(ctx, ServiceTestRid().CallProgress(), nil, &result, []byte("token-string"))
+	s.Require().Nil(err, "timeout should have been extended")
+	s.Require().Equal("done", result["status"])
+	s.Require().Equal([]int{1, 2}, steps, "progress should have been received in order")
+}
+
+func (s *NatsTest) TestCallContextExtended() {
+	provider := s.newProvider(nats.Config{
+		Logger:  log.New(os.Stderr, "test", log.LstdFlags),
+		Timeout: 100 * time.Millisecond,
+	})
+	defer provider.Close()
+
+	// The handler uses its context and makes a nested call after the Provider timeout it extended
+	var left time.Duration
+	err := provider.Request(ServiceTestRid().CallExtended(), nil, &left, []byte("token-string"))
+	s.Require().Nil(err, "call context should have been extended")
+	s.Require().Greater(left, time.Duration(0), "nested call should inherit the extended deadline")
+}
+
+func (s *NatsTest) TestRequestCtxCanceled() {
+	ctx, cancel := context.WithCancel(s.ctx)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	err := s.httpBroker.RequestCtx(ctx, ServiceTestRid().CallSlow(), nil, nil, []byte("token-string"))
+	s.Require().NotNil(err, "should have been canceled")
+	s.Require().Equal(broker.StatusClientClosedRequest, err.Code(), "incorrect error")
+}
+
+func (s *NatsTest) TestCallContextDeadline() {
+	ctx, cancel := context.WithTimeout(s.ctx, 2*time.Second)
+	defer cancel()
+	var left time.Duration
+	err := s.httpBroker.RequestCtx(ctx, ServiceTestRid().CallDeadline(), nil, &left, []byte("token-string"))
+	s.Require().Nil(err, "error response")
+	s.Require().Greater(left, time.Duration(0), "deadline should not have expired")
+	s.Require().LessOrEqual(left, 2*time.Second, "deadline should come from the caller")
+}
+
+func (s *NatsTest) TestCallContextNestedDeadline() {
+	ctx, cancel := context.WithTimeout(s.ctx, 2*time.Second)
+	defer cancel()
+	var left time.Duration
+	err := s.httpBroker.RequestCtx(ctx, ServiceTestRid().CallNestedDeadline(), nil, &left, []byte("token-string"))
+	s.Require().Nil(err, "error response")
+	s.Require().Greater(left, time.Duration(0), "deadline should not have expired")
+	s.Require().LessOrEqual(left, 2*time.Second, "nested call should inherit the deadline")
+}
+
+func (s *NatsTest) TestNestedHeaders() {
+	var headers map[string]string
+	err := Request(ServiceTestRid().CallNestedHeaders(), nil, &headers, "token-string")
+	s.Require().Nil(err, "error response")
+	s.Require().NotEmpty(headers[broker.HeaderRequestID], "request ID should reach nested calls")
+	s.Require().NotEmpty(headers[broker.HeaderUserAgent], "user agent should reach nested calls")
+	s.Require().Equal(ServiceTestRid().Name(), headers[broker.HeaderCallerService], "invalid caller service")
+}
+
+func (s *NatsTest) TestTracePropagation() {
+	ctx, span := tracing.Tracer().Start(s.ctx, "test")
+	var headers map[string]string
+	err := s.httpBroker.RequestCtx(ctx, ServiceTestRid().CallNestedHeaders(), nil, &headers, []byte("token-string"))
+	span.End()
+	s.Require().Nil(err, "error response")
+	s.Require().NotEmpty(headers["traceparent"], "trace context should reach nested calls")
+
+	s.Require().Nil(s.tracer.ForceFlush(s.ctx))
+	var servers int
+	for _, stub := range s.spans.GetSpans() {
+		if stub.SpanContext.TraceID() == span.SpanContext().TraceID() && stub.SpanKind == trace.SpanKindServer {
+			servers++
+		}
+	}
+	s.Require().Equal(2, servers, "both handlers should be traced")
+}
+
+func (s *NatsTest) TestInterceptors() {
+	var kinds []broker.InvocationKind
+	var kindsM sync.Mutex
+	provider := s.newProvider(nats.Config{
+		Logger: log.New(os.Stderr, "test", log.LstdFlags),
+		ProviderOptions: broker.ProviderOptions{
+			Interceptors: []broker.Interceptor{
+				func(ctx context.Context, inv *broker.Invocation, next broker.Invoker) broker.Error {
+					kindsM.Lock()
+					kinds = append(kinds, inv.Kind)
+					kindsM.Unlock()
+					return next(ctx, inv)
+				},
+				func(ctx context.Context, inv *broker.Invocation, next broker.Invoker) broker.Error {
+					if inv.Pattern.EndpointName() == ServiceTestRid().CallSlow().EndpointName() {
+						return broker.ErrorStatusForbidden
+					}
+					inv.Token = []byte("token-string")
+					return next(ctx, inv)
+				},
+			},
+		},
+	})
+	defer provider.Close()
+
+	var id uuid.UUID
+	err := provider.Request(ServiceTestRid().TestReply(s.id), s.id, &id)
+	s.Require().Nil(err, "interceptor should have injected the token")
+	s.Require().Equal(s.id, id, "invalid response")
+
+	err = provider.Get(ServiceTestRid().CallSlow(), nil)
+	s.Require().NotNil(err, "interceptor should have short-circuited the call")
+	s.Require().Equal(http.StatusForbidden, err.Code(), "incorrect error")
+	kindsM.Lock()
+	defer kindsM.Unlock()
+	s.Require().Equal([]broker.InvocationKind{broker.InvocationRequest, broker.InvocationRequest}, kinds)
+}
+
+func (s *NatsTest) TestSubscriptionMiddleware() {
+	var answer map[string]string
+	err := Request(ServiceTestRid().CallMiddleware(), nil, &answer, "token-string")
+	s.Require().Nil(err, "error response")
+	s.Require().Equal("middleware", answer["answeredBy"], "middleware should have answered the call")
+}
+
+func (s *NatsTest) TestRetryPolicy() {
+	err := s.httpBroker.Get(ServiceTestRid().CallFlaky(), nil, []byte("token-string"))
+	s.Require().Nil(err, "failed attempt should have been retried")
+}
+
+func (s *NatsTest) TestCircuitBreaker() {
+	events := make(chan broker.CircuitBreakerEvent, 3)
+	unsubscribe, rErr := s.serviceBroker.Monitor("circuitBreakerTest",
+		broker.Subscription{Resource: rids.Spike().EventCircuitBreakerChanged()},
+		func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+			call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+			if err != nil {
+				return
+			}
+			var event broker.CircuitBreakerEvent
+			if json.Unmarshal(call.RawData(), &event) == nil && event.Endpoint == ServiceTestRid().NoHandler().EndpointName() {
+				events <- event
+			}
+		})
+	s.Require().Nil(rErr, "failed to monitor circuit breaker events")
+	defer unsubscribe()
+
+	provider := s.newProvider(nats.Config{
+		Logger: log.New(os.Stderr, "test", log.LstdFlags),
+		ProviderOptions: broker.ProviderOptions{
+			CircuitBreaker: &broker.CircuitBreakerOptions{
+				Failures:    2,
+				OpenTimeout: 100 * time.Millisecond,
+			},
+		},
+	})
+	defer provider.Close()
+
+	nextState := func() broker.CircuitState {
+		select {
+		case event := <-events:
+			return event.State
+		case <-time.After(time.Second):
+			return ""
+		}
+	}
+
+	for range []int{1, 2} {
+		err := provider.Get(ServiceTestRid().NoHandler(), nil)
+		s.Require().NotNil(err, "should have failed")
+	}
+	s.Require().Equal(broker.CircuitOpen, nextState(), "circuit should have opened")
+
+	err := provider.Get(ServiceTestRid().NoHandler(), nil)
+	s.Require().Equal(broker.ErrorServiceUnavailable, err, "open circuit should fail fast")
+
+	time.Sleep(150 * time.Millisecond)
+	err = provider.Get(ServiceTestRid().NoHandler(), nil)
+	s.Require().NotNil(err, "probe should have failed")
+	s.Require().ElementsMatch([]broker.CircuitState{broker.CircuitHalfOpen, broker.CircuitOpen},
+		[]broker.CircuitState{nextState(), nextState()}, "failed probe should open the circuit again")
+}
+
+func (s *NatsTest) TestMessagePackCodec() {
+	provider := s.newProvider(nats.Config{
+		Logger: log.New(os.Stderr, "test", log.LstdFlags),
+		ProviderOptions: broker.ProviderOptions{
+			Codec: broker.MessagePackCodec,
+		},
+	})
+	defer provider.Close()
+
+	// First call negotiates the codec, the following ones use it
+	for range []int{1, 2, 3} {
+		payload := map[string]interface{}{
+			"attr1": 10,
+			"attr2": "Ok",
+		}
+		var respPayload map[string]interface{}
+		err := provider.Request(ServiceTestRid().CallWithObjPayload(), payload, &respPayload, []byte("token-string"))
+		s.Require().Nil(err, "error response")
+		s.Require().Equal(float64(10), respPayload["attr1"], "invalid response")
+		s.Require().Equal("Ok", respPayload["attr2"], "invalid response")
+
+		var id uuid.UUID
+		err = provider.Request(ServiceTestRid().TestReply(s.id), s.id, &id, []byte("invalid-token"))
+		s.Require().NotNil(err, "should return error")
+		s.Require().Equal(http.StatusUnauthorized, err.Code(), "incorrect error")
+	}
+}
+
+func (s *NatsTest) TestCompression() {
+	provider := s.newProvider(nats.Config{
+		Logger: log.New(os.Stderr, "test", log.LstdFlags),
+		ProviderOptions: broker.ProviderOptions{
+			Compression: &broker.CompressionOptions{
+				Algorithm: broker.CompressionZstd,
+				MinSize:   64,
+			},
+		},
+	})
+	defer provider.Close()
+
+	// First call learns the service understands compression, the following ones are compressed
+	large := strings.Repeat("compressible ", 1024)
+	for range []int{1, 2} {
+		payload := map[string]interface{}{
+			"attr1": 10,
+			"attr2": large,
+		}
+		var respPayload map[string]interface{}
+		err := provider.Request(ServiceTestRid().CallWithObjPayload(), payload, &respPayload, []byte("token-string"))
+		s.Require().Nil(err, "error response")
+		s.Require().Equal(large, respPayload["attr2"], "invalid response")
+	}
+
+	// Envelopes the receiver would refuse to decompress are sent as they are
+	huge := strings.Repeat("a", int(broker.MaxDecompressedSize)+1)
+	var respPayload map[string]interface{}
+	err := provider.Request(ServiceTestRid().CallWithObjPayload(), map[string]interface{}{"attr1": 10, "attr2": huge},
+		&respPayload, []byte("token-string"))
+	s.Require().Nil(err, "envelopes over the decompression limit should not be compressed")
+	s.Require().Equal(huge, respPayload["attr2"], "invalid response")
+}
+
+func (s *NatsTest) TestStreamResponse() {
+	chunks, rErr := s.httpBroker.RequestStream(s.ctx, ServiceTestRid().CallStream(), &StreamRequest{Count: 3},
+		[]byte("token-string"))
+	s.Require().Nil(rErr, "failed to start stream")
+	var indexes []int
+	for chunk := range chunks {
+		s.Require().Nil(chunk.Err, "unexpected stream error")
+		var received StreamChunk
+		s.Require().Nil(chunk.Decode(&received), "invalid chunk")
+		indexes = append(indexes, received.Index)
+	}
+	s.Require().Equal([]int{0, 1, 2}, indexes, "chunks out of order")
+
+	chunks, rErr = s.httpBroker.RequestStream(s.ctx, ServiceTestRid().CallStream(),
+		&StreamRequest{Count: 1, Fail: true}, []byte("token-string"))
+	s.Require().Nil(rErr, "failed to start stream")
+	var last broker.StreamChunk
+	for chunk := range chunks {
+		last = chunk
+	}
+	s.Require().NotNil(last.Err, "stream should have ended with an error")
+	s.Require().Equal(http.StatusNotFound, last.Err.Code(), "incorrect error")
+
+	// Callers not reading streams receive the chunks at once
+	var buffered []StreamChunk
+	err := Request(ServiceTestRid().CallStream(), &StreamRequest{Count: 2}, &buffered, "token-string")
+	s.Require().Nil(err, "error response")
+	s.Require().Equal([]StreamChunk{{Index: 0}, {Index: 1}}, buffered, "invalid buffered response")
+
+	data, _ := json.Marshal(&StreamRequest{Count: 2})
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:3333"+ServiceTestRid().CallStream().EndpointREST(),
+		strings.NewReader(string(data)))
+	req.Header.Set("Authorization", "Bearer token-string")
+	req.Header.Set("Accept", "application/x-ndjson")
+	res, httpErr := http.DefaultClient.Do(req)
+	s.Require().Nil(httpErr, "failed to request stream over HTTP")
+	defer res.Body.Close()
+	s.Require().Equal(http.StatusOK, res.StatusCode, "invalid status")
+	s.Require().Equal("application/x-ndjson", res.Header.Get("Content-Type"), "invalid content type")
+	body, _ := io.ReadAll(res.Body)
+	s.Require().Equal("{\"index\":0}\n{\"index\":1}\n", string(body), "invalid NDJSON stream")
+}
+
+func (s *NatsTest) TestRequestAll() {
+	unsubscribe, rErr := s.httpBroker.Subscribe(broker.Subscription{
+		Resource:  ServiceTestRid().CallInstance(),
+		Broadcast: true,
+	}, func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+		call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+		if err != nil {
+			return
+		}
+		call.SetProvider(s.httpBroker)
+		call.OK("second")
+	})
+	s.Require().Nil(rErr, "failed to subscribe second instance")
+	defer unsubscribe()
+
+	replies, rErr := s.httpBroker.RequestAll(s.ctx, ServiceTestRid().CallInstance(), nil,
+		broker.RequestAllOptions{Timeout: time.Second, Expected: 2}, []byte("token-string"))
+	s.Require().Nil(rErr, "failed to gather replies")
+	s.Require().Len(replies, 2, "every instance should have answered")
+	var answers []string
+	for _, reply := range replies {
+		s.Require().Nil(reply.Err, "unexpected instance error")
+		answers = append(answers, string(reply.Data))
+	}
+	s.Require().Contains(answers, "second", "second instance reply missing")
+
+	var keys []string
+	err := Request(ServiceTestRid().CallGather(), nil, &keys, "token-string")
+	s.Require().Nil(err, "error response")
+	s.Require().Len(keys, 2, "gathering until the timeout should reach every instance")
+}
+
+func (s *NatsTest) TestRequestAsync() {
+	var ids []uuid.UUID
+	err := Request(ServiceTestRid().CallAsync(), nil, &ids, "token-string")
+	s.Require().Nil(err, "error response")
+	s.Require().Len(ids, 2, "both futures should have been answered")
+	s.Require().NotEqual(ids[0], ids[1], "futures mixed their responses")
+
+	slow := s.httpBroker.RequestAsync(s.ctx, ServiceTestRid().CallSlow(), nil, nil, []byte("token-string"))
+	err = broker.WaitAll(s.ctx, 50*time.Millisecond, slow)
+	s.Require().NotNil(err, "should have timed out")
+	s.Require().Equal(broker.ErrorTimeout.Code(), err.Code(), "incorrect error")
+
+	err = slow.Wait()
+	s.Require().NotNil(err, "pending future should have been cancelled")
+	s.Require().Equal(broker.ErrorRequestCanceled.Code(), err.Code(), "incorrect error")
+}
+
+func (s *NatsTest) TestTypedHelpers() {
+	id, err := broker.Request[uuid.UUID, uuid.UUID](s.httpBroker, ServiceTestRid().TestReply(s.id), s.id,
+		[]byte("token-string"))
+	s.Require().Nil(err, "error response")
+	s.Require().Equal(s.id, id, "invalid response")
+
+	_, err = broker.Request[string, []StreamChunk](s.httpBroker, ServiceTestRid().CallStream(), "invalid",
+		[]byte("token-string"))
+	s.Require().NotNil(err, "invalid payload should have been rejected")
+	s.Require().Equal(broker.ErrorInvalidParams.Code(), err.Code(), "incorrect error")
+}
+
+func (s *NatsTest) TestIndependentProviders() {
+	provider := s.newProvider(nats.Config{
+		Logger:      log.New(os.Stderr, "test", log.LstdFlags),
+		Connections: 2,
+		Timeout:     time.Second,
+	})
+
+	var id uuid.UUID
+	err := provider.Request(ServiceTestRid().TestReply(s.id), s.id, &id, []byte("token-string"))
+	s.Require().Nil(err, "error response")
+	s.Require().Equal(s.id, id, "invalid response")
+
+	// Closing a Provider must not affect the others running in the process
+	provider.Close()
+	err = s.httpBroker.Request(ServiceTestRid().TestReply(s.id), s.id, &id, []byte("token-string"))
+	s.Require().Nil(err, "other providers should keep working")
+}
+
+func (s *NatsTest) TestSubscriptionOverflow() {
+	futures := make([]broker.Future, 2)
+	for i := range futures {
+		futures[i] = s.httpBroker.RequestAsync(s.ctx, ServiceTestRid().CallLimited(), nil, nil,
+			[]byte("token-string"))
+	}
+
+	var codes []int
+	for _, f := range futures {
+		if err := f.Wait(); err != nil {
+			codes = append(codes, err.Code())
+		} else {
+			codes = append(codes, http.StatusOK)
+		}
+	}
+	s.Require().ElementsMatch([]int{http.StatusOK, http.StatusServiceUnavailable}, codes,
+		"the call over MaxInFlight should have been rejected")
+}
+
 func (s *NatsTest) TestEventOne() {
 	err := s.serviceBroker.Publish(ServiceTestRid().EventOneTest(spikeutils.Stringer("EventOneValue")),
 		nil, nil, []byte("token-string"))
 	s.Require().Nil(err, "return should be nil")
 }
 
+func (s *NatsTest) TestCallProviderAfterReply() {
+	received := make(chan string, 10)
+	unsubscribe, rErr := s.httpBroker.Monitor("backgroundTest",
+		broker.Subscription{Resource: ServiceTestRid().EventOneTest()},
+		func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+			call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+			if err != nil {
+				return
+			}
+			var value string
+			if json.Unmarshal(call.RawData(), &value) == nil {
+				received <- value
+			}
+		})
+	s.Require().Nil(rErr, "failed to monitor event one")
+	defer unsubscribe()
+
+	// The handler answers before publishing through Call.Provider
+	s.Require().Nil(s.httpBroker.Request(ServiceTestRid().CallBackground(), nil, nil, []byte("token-string")))
+	select {
+	case value := <-received:
+		s.Require().Equal("background", value)
+	case <-time.After(5 * time.Second):
+		s.FailNow("event published after the reply not received")
+	}
+}
+
+func (s *NatsTest) TestDurableEvent() {
+	received := make(chan string, 10)
+	monitor := func() func() {
+		unsubscribe, rErr := s.httpBroker.Monitor("durableTest",
+			broker.Subscription{Resource: ServiceTestRid().EventDurableTest()},
+			func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+				call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+				if err != nil {
+					return
+				}
+				var value string
+				if json.Unmarshal(call.RawData(), &value) == nil {
+					received <- value
+				}
+			})
+		s.Require().Nil(rErr, "failed to monitor durable event")
+		return unsubscribe
+	}
+	expect := func(value string) {
+		select {
+		case got := <-received:
+			s.Require().Equal(value, got)
+		case <-time.After(5 * time.Second):
+			s.FailNow("durable event not received", value)
+		}
+	}
+
+	unsubscribe := monitor()
+	p := ServiceTestRid().EventDurableTest(spikeutils.Stringer("durableValue"))
+	s.Require().Nil(s.serviceBroker.Publish(p, "first"))
+	expect("first")
+	unsubscribe()
+
+	// Published while the group is not monitoring, it must be delivered once it is back
+	s.Require().Nil(s.serviceBroker.Publish(p, "missed"))
+	unsubscribe = monitor()
+	defer unsubscribe()
+	expect("missed")
+}
+
+func (s *NatsTest) TestEphemeralDurableEvent() {
+	received := make(chan string, 10)
+	monitor := func() func() {
+		unsubscribe, rErr := s.httpBroker.Monitor("ephemeralTest", broker.Subscription{
+			Resource:  ServiceTestRid().EventDurableTest(),
+			Ephemeral: true,
+		}, func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+			call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+			if err != nil {
+				return
+			}
+			var value string
+			if json.Unmarshal(call.RawData(), &value) == nil {
+				received <- value
+			}
+		})
+		s.Require().Nil(rErr, "failed to monitor durable event")
+		return unsubscribe
+	}
+	expect := func(value string) {
+		select {
+		case got := <-received:
+			s.Require().Equal(value, got)
+		case <-time.After(5 * time.Second):
+			s.FailNow("durable event not received", value)
+		}
+	}
+
+	unsubscribe := monitor()
+	p := ServiceTestRid().EventDurableTest(spikeutils.Stringer("ephemeralValue"))
+	s.Require().Nil(s.serviceBroker.Publish(p, "first"))
+	expect("first")
+	unsubscribe()
+
+	// Published while the ephemeral monitor is not subscribed, it must not be kept for it
+	s.Require().Nil(s.serviceBroker.Publish(p, "missed"))
+	unsubscribe = monitor()
+	defer unsubscribe()
+	s.Require().Nil(s.serviceBroker.Publish(p, "second"))
+	expect("second")
+}
+
+func (s *NatsTest) TestDurableEventOverflow() {
+	received := make(chan string, 10)
+	release := make(chan struct{})
+	unsubscribe, rErr := s.httpBroker.Monitor("overflowTest", broker.Subscription{
+		Resource:    ServiceTestRid().EventDurableTest(),
+		MaxInFlight: 1,
+		Overflow:    broker.OverflowDrop,
+	}, func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+		call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+		s.Require().Nil(err)
+		var value string
+		s.Require().Nil(json.Unmarshal(call.RawData(), &value))
+		received <- value
+		if value == "blocking" {
+			<-release
+		}
+	})
+	s.Require().Nil(rErr, "failed to monitor durable event")
+	defer unsubscribe()
+
+	// The second event is dropped while the only worker is busy, it must be delivered again
+	p := ServiceTestRid().EventDurableTest(spikeutils.Stringer("overflowValue"))
+	s.Require().Nil(s.serviceBroker.Publish(p, "blocking"))
+	s.Require().Equal("blocking", <-received)
+	s.Require().Nil(s.serviceBroker.Publish(p, "dropped"))
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	select {
+	case value := <-received:
+		s.Require().Equal("dropped", value)
+	case <-time.After(5 * time.Second):
+		s.FailNow("dropped durable event not redelivered")
+	}
+}
+
+func (s *NatsTest) TestDurableEventRedelivery() {
+	var attempts int32
+	received := make(chan string, 10)
+	unsubscribe, rErr := s.httpBroker.Monitor("redeliveryTest", broker.Subscription{
+		Resource: ServiceTestRid().EventDurableTest(),
+		Redelivery: &rids.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: 10 * time.Millisecond,
+		},
+	}, func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+		call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+		s.Require().Nil(err)
+		call.SetProvider(s.httpBroker)
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			call.Error(broker.ErrorServiceUnavailable)
+			return
+		}
+		var value string
+		s.Require().Nil(json.Unmarshal(call.RawData(), &value))
+		received <- value
+	})
+	s.Require().Nil(rErr, "failed to monitor durable event")
+	defer unsubscribe()
+
+	p := ServiceTestRid().EventDurableTest(spikeutils.Stringer("redeliveryValue"))
+	s.Require().Nil(s.serviceBroker.Publish(p, "redelivered"))
+	select {
+	case value := <-received:
+		s.Require().Equal("redelivered", value)
+	case <-time.After(5 * time.Second):
+		s.FailNow("rejected durable event not redelivered")
+	}
+	s.Require().EqualValues(2, atomic.LoadInt32(&attempts))
+}
+
+func (s *NatsTest) TestIdempotentDurableEvent() {
+	received := make(chan string, 10)
+	unsubscribe, rErr := s.httpBroker.Monitor("idempotencyTest",
+		broker.Subscription{Resource: ServiceTestRid().EventDurableTest()},
+		func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+			call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+			s.Require().Nil(err)
+			received <- call.Headers()[broker.HeaderIdempotencyKey]
+		})
+	s.Require().Nil(rErr, "failed to monitor durable event")
+	defer unsubscribe()
+
+	// The retry of the first event must be discarded by JetStream
+	p := ServiceTestRid().EventDurableTest(spikeutils.Stringer("idempotentValue"))
+	ctx := broker.WithIdempotencyKey(context.Background(), "event-1")
+	s.Require().Nil(s.serviceBroker.PublishCtx(ctx, p, "payload"))
+	s.Require().Nil(s.serviceBroker.PublishCtx(ctx, p, "payload"))
+	s.Require().Nil(s.serviceBroker.PublishCtx(broker.WithIdempotencyKey(context.Background(), "event-2"), p,
+		"payload"))
+
+	var keys []string
+	for range []int{1, 2} {
+		select {
+		case key := <-received:
+			keys = append(keys, key)
+		case <-time.After(5 * time.Second):
+			s.FailNow("durable event not received", keys)
+		}
+	}
+	if s.memory {
+		// The memory Provider runs the events on concurrent handlers, so they may arrive in any order
+		s.Require().ElementsMatch([]string{"event-1", "event-2"}, keys)
+	} else {
+		s.Require().Equal([]string{"event-1", "event-2"}, keys)
+	}
+	select {
+	case key := <-received:
+		s.FailNow("duplicated event received", key)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func (s *NatsTest) TestSecureConnection() {
+	s.requireNats()
+	dir := s.T().TempDir()
+	certs, err := WriteTestCertificates(dir)
+	s.Require().Nil(err, "failed to generate certificates")
+
+	user, err := nkeys.CreateUser()
+	s.Require().Nil(err, "failed to create nkey")
+	publicKey, err := user.PublicKey()
+	s.Require().Nil(err)
+	seed, err := user.Seed()
+	s.Require().Nil(err)
+	seedFile := filepath.Join(dir, "user.nk")
+	s.Require().Nil(os.WriteFile(seedFile, seed, 0600))
+
+	clientTLS := &nats.TLSConfig{
+		CAFile:   certs.CAFile,
+		CertFile: certs.ClientCertFile,
+		KeyFile:  certs.ClientKeyFile,
+	}
+	server, serverURL := nats.NewNatsProviderWithURL(nats.Config{
+		LocalNats: true,
+		LocalServer: nats.ServerConfig{
+			Port: nats.RandomPort,
+			TLS: &nats.TLSConfig{
+				CAFile:   certs.CAFile,
+				CertFile: certs.ServerCertFile,
+				KeyFile:  certs.ServerKeyFile,
+			},
+			Authorization: &nats.LocalAuthorization{
+				Users: map[string]string{"spike": "secret"},
+				NKeys: []string{publicKey},
+			},
+		},
+		TLS:         clientTLS,
+		User:        "spike",
+		Password:    "secret",
+		Connections: 2,
+		Logger:      log.New(os.Stderr, "test", log.LstdFlags),
+	})
+	defer server.Close()
+
+	unsubscribe, rErr := server.Subscribe(broker.Subscription{Resource: ServiceTestRid().CallInstance()},
+		func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+			call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+			s.Require().Nil(err)
+			call.SetProvider(server)
+			call.OK(map[string]bool{"secure": true})
+		})
+	s.Require().Nil(rErr, "failed to subscribe on the secure server")
+	defer unsubscribe()
+
+	client := nats.NewNatsProvider(nats.Config{
+		NatsURL:      serverURL,
+		TLS:          clientTLS,
+		NKeySeedFile: seedFile,
+		Connections:  2,
+		Logger:       log.New(os.Stderr, "test", log.LstdFlags),
+	})
+	defer client.Close()
+
+	var result map[string]bool
+	s.Require().Nil(client.Request(ServiceTestRid().CallInstance(), nil, &result), "nkey user should be allowed")
+	s.Require().True(result["secure"])
+
+	s.Require().Panics(func() {
+		nats.NewNatsProvider(nats.Config{
+			NatsURL:     serverURL,
+			TLS:         clientTLS,
+			Connections: 1,
+		})
+	}, "connections without credentials should be refused")
+}
+
+func (s *NatsTest) TestEmbeddedCluster() {
+	s.requireNats()
+	logger := log.New(os.Stderr, "test", log.LstdFlags)
+	first, firstURL := nats.NewNatsProviderWithURL(nats.Config{
+		LocalNats: true,
+		LocalServer: nats.ServerConfig{
+			Name:        "first",
+			Port:        nats.RandomPort,
+			MonitorPort: nats.RandomPort,
+			Cluster:     &nats.ClusterConfig{Name: "spike", Port: 6401},
+		},
+		Connections: 2,
+		Logger:      logger,
+	})
+	defer first.Close()
+
+	second, secondURL := nats.NewNatsProviderWithURL(nats.Config{
+		LocalNats: true,
+		LocalServer: nats.ServerConfig{
+			Name: "second",
+			Port: nats.RandomPort,
+			Cluster: &nats.ClusterConfig{
+				Name:   "spike",
+				Port:   6402,
+				Routes: []string{"nats://127.0.0.1:6401"},
+			},
+		},
+		Connections: 2,
+		Logger:      logger,
+	})
+	defer second.Close()
+	s.Require().NotEqual(firstURL, secondURL, "local servers should not collide")
+
+	unsubscribe, rErr := first.Subscribe(broker.Subscription{Resource: ServiceTestRid().CallInstance()},
+		func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+			call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+			s.Require().Nil(err)
+			call.SetProvider(first)
+			call.OK(map[string]string{"server": "first"})
+		})
+	s.Require().Nil(rErr, "failed to subscribe on the first server")
+	defer unsubscribe()
+
+	// The subscription reaches the second server once the route is up
+	s.Require().Eventually(func() bool {
+		var result map[string]string
+		ctx, cancel := context.WithTimeout(s.ctx, 100*time.Millisecond)
+		defer cancel()
+		return second.RequestCtx(ctx, ServiceTestRid().CallInstance(), nil, &result) == nil &&
+			result["server"] == "first"
+	}, 5*time.Second, 50*time.Millisecond, "request should be routed to the first server")
+}
+
+func (s *NatsTest) TestConnectionLifecycle() {
+	s.requireNats()
+	logger := log.New(os.Stderr, "test", log.LstdFlags)
+	serverConfig := nats.Config{
+		LocalNats:   true,
+		LocalServer: nats.ServerConfig{Port: 6403},
+		Connections: 1,
+		Logger:      logger,
+	}
+	server, url := nats.NewNatsProviderWithURL(serverConfig)
+
+	kinds := make(chan nats.LifecycleKind, 10)
+	client := nats.NewNatsProvider(nats.Config{
+		NatsURL:     url,
+		Connections: 2,
+		Logger:      logger,
+		LifecycleHandlers: []nats.LifecycleHandler{func(event nats.LifecycleEvent) {
+			select {
+			case kinds <- event.Kind:
+			default:
+			}
+		}},
+	})
+	defer client.Close()
+
+	published := make(chan nats.LifecycleEvent, 10)
+	unsubscribe, rErr := client.Monitor("lifecycleTest",
+		broker.Subscription{Resource: rids.Spike().EventConnectionChanged()},
+		func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+			call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+			if err != nil {
+				return
+			}
+			var event nats.LifecycleEvent
+			if json.Unmarshal(call.RawData(), &event) == nil {
+				select {
+				case published <- event:
+				default:
+				}
+			}
+		})
+	s.Require().Nil(rErr, "failed to monitor connection events")
+	defer unsubscribe()
+
+	status := client.Status()
+	s.Require().True(status.Healthy, "client should be healthy")
+	s.Require().Equal(2, status.Connected, "every connection should be up")
+
+	server.Close()
+	s.Require().Equal(nats.LifecycleDisconnected, <-kinds, "handler should have been notified")
+	s.Require().Eventually(func() bool {
+		status = client.Status()
+		return !status.Healthy && status.Connected == 0
+	}, time.Second, 10*time.Millisecond, "client should be unhealthy")
+
+	server = nats.NewNatsProvider(serverConfig)
+	defer server.Close()
+	s.Require().Eventually(func() bool { return client.Status().Healthy }, 10*time.Second, 50*time.Millisecond,
+		"client should have reconnected")
+
+	// The server publishes it is closed before its connections close, the client publishes its reconnection
+	kindsPublished := map[nats.LifecycleKind]bool{}
+	timeout := time.After(time.Second)
+	for !kindsPublished[nats.LifecycleReconnected] {
+		select {
+		case event := <-published:
+			kindsPublished[event.Kind] = true
+		case <-timeout:
+			s.FailNow("connection events should have been published", kindsPublished)
+		}
+	}
+	s.Require().True(kindsPublished[nats.LifecycleClosed], "server closed event should have been published")
+
+	// Closing the client reports it once, not once per connection
+	for len(kinds) > 0 {
+		<-kinds
+	}
+	client.Close()
+	closed := 0
+	for len(kinds) > 0 {
+		if <-kinds == nats.LifecycleClosed {
+			closed++
+		}
+	}
+	s.Require().Equal(1, closed, "closed should have been reported once")
+}
+
+func (s *NatsTest) TestProviderStatus() {
+	provider := s.newProvider(nats.Config{Connections: 1})
+	s.Require().True(provider.Status().Healthy, "provider should be healthy")
+	provider.Close()
+	s.Require().False(provider.Status().Healthy, "closed provider should not be healthy")
+}
+
+func (s *NatsTest) TestStuckMonitor() {
+	provider := s.newProvider(nats.Config{Connections: 1})
+	defer provider.Close()
+	release := make(chan struct{})
+	defer close(release)
+	unsubscribe, rErr := provider.Monitor("stuckTest", broker.Subscription{
+		Resource:    ServiceTestRid().EventFailingTest(),
+		MaxInFlight: 1,
+		Overflow:    broker.OverflowDrop,
+	}, func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+		<-release
+	})
+	s.Require().Nil(rErr, "failed to monitor event")
+	defer unsubscribe()
+
+	// Events over the subscription buffers are dropped instead of holding the publisher
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < 500; i++ {
+			s.Require().Nil(s.serviceBroker.Publish(ServiceTestRid().EventFailingTest(), "stuck"))
+		}
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		s.FailNow("publisher held by a stuck monitor")
+	}
+}
+
+func (s *NatsTest) TestDrain() {
+	drain := func(timeout time.Duration) (int, broker.Error) {
+		provider := s.newProvider(nats.Config{
+			Connections: 2,
+			Logger:      log.New(os.Stderr, "test", log.LstdFlags),
+		})
+		started := make(chan struct{})
+		_, rErr := provider.Subscribe(broker.Subscription{Resource: ServiceTestRid().NoHandler()},
+			func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+				call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+				s.Require().Nil(err)
+				call.SetProvider(provider)
+				close(started)
+				time.Sleep(300 * time.Millisecond)
+				call.OK(map[string]string{"status": "done"})
+			})
+		s.Require().Nil(rErr, "failed to subscribe")
+
+		ctx, cancel := context.WithTimeout(s.ctx, time.Second)
+		defer cancel()
+		future := s.httpBroker.RequestAsync(ctx, ServiceTestRid().NoHandler(), nil, nil)
+		<-started
+
+		drainCtx, drainCancel := context.WithTimeout(s.ctx, timeout)
+		defer drainCancel()
+		abandoned := provider.Drain(drainCtx)
+		return abandoned, future.Wait()
+	}
+
+	abandoned, rErr := drain(2 * time.Second)
+	s.Require().Equal(0, abandoned, "running call should have been waited for")
+	s.Require().Nil(rErr, "running call should have been answered")
+
+	abandoned, rErr = drain(50 * time.Millisecond)
+	s.Require().Equal(1, abandoned, "running call should have been abandoned")
+	s.Require().NotNil(rErr, "abandoned call should not be answered")
+
+	// Pending requests made by the Provider are waited for as well
+	provider := s.newProvider(nats.Config{Connections: 1})
+	future := provider.RequestAsync(s.ctx, ServiceTestRid().CallSlow(), nil, nil, []byte("token-string"))
+	ctx, cancel := context.WithTimeout(s.ctx, 2*time.Second)
+	defer cancel()
+	s.Require().Equal(0, provider.Drain(ctx), "pending request should have been waited for")
+	s.Require().Nil(future.Wait(), "pending request should have been answered")
+}
+
+func (s *NatsTest) TestServiceStop() {
+	logger := log.New(os.Stderr, "test", log.LstdFlags)
+	shared := s.newProvider(nats.Config{Connections: 1, Logger: logger})
+	defer shared.Close()
+
+	svc := spike.NewAPIService()
+	s.Require().Nil(svc.Setup(spike.Options{
+		Service:       NewServiceTest(shared, logger),
+		Authenticator: NewAuthenticator(),
+		Authorizer:    NewAuthorizer(),
+		DrainTimeout:  time.Second,
+	}))
+	s.Require().Nil(svc.StartService())
+	s.Require().Nil(svc.Stop(), "no call should have been abandoned")
+
+	// The Provider belongs to the caller, it must still work once the Service stops
+	s.Require().Nil(shared.Request(ServiceTestRid().CallSlow(), nil, nil, []byte("token-string")),
+		"shared provider should not have been closed")
+}
+
+func (s *NatsTest) TestDeadLetter() {
+	var attempts int32
+	fail := int32(1)
+	unsubscribe, rErr := s.httpBroker.Monitor("deadLetterTest", broker.Subscription{
+		Resource: ServiceTestRid().EventFailingTest(),
+		Redelivery: &rids.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: 10 * time.Millisecond,
+		},
+	}, func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+		atomic.AddInt32(&attempts, 1)
+		call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+		s.Require().Nil(err)
+		s.Require().Empty(call.Reply(), "monitored events must have no reply")
+		call.SetProvider(s.httpBroker)
+		if atomic.LoadInt32(&fail) == 1 {
+			call.Error(broker.ErrorServiceUnavailable)
+			return
+		}
+		call.OK()
+	})
+	s.Require().Nil(rErr, "failed to monitor failing event")
+	defer unsubscribe()
+
+	dlq := make(chan broker.DeadLetter, 1)
+	unsubscribeDLQ, rErr := s.httpBroker.Monitor("deadLetterQueueTest",
+		broker.Subscription{Resource: rids.DeadLetter(ServiceTestRid().EventFailingTest())},
+		func(sub broker.Subscription, payload []byte, replyEndpoint string) {
+			call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
+			s.Require().Nil(err)
+			var letter broker.DeadLetter
+			s.Require().Nil(json.Unmarshal(call.RawData(), &letter))
+			dlq <- letter
+		})
+	s.Require().Nil(rErr, "failed to monitor dead letters")
+	defer unsubscribeDLQ()
+
+	p := ServiceTestRid().EventFailingTest(spikeutils.Stringer("failingValue"))
+	s.Require().Nil(s.serviceBroker.Publish(p, "payload"))
+
+	var letter broker.DeadLetter
+	select {
+	case letter = <-dlq:
+	case <-time.After(5 * time.Second):
+		s.FailNow("event not dead-lettered", "attempts %d", atomic.LoadInt32(&attempts))
+	}
+	s.Require().EqualValues(3, atomic.LoadInt32(&attempts))
+	s.Require().Equal(3, letter.Attempts)
+	s.Require().Equal(http.StatusServiceUnavailable, letter.Code)
+	s.Require().Equal(p.EndpointNameSpecific(), letter.Endpoint)
+
+	held, rErr := s.httpBroker.DeadLetter(letter.ID)
+	s.Require().Nil(rErr, "dead letter should be held by the monitoring provider")
+	s.Require().Equal("deadLetterTest", held.Group)
+	s.Require().Len(s.httpBroker.DeadLetters(), 1)
+
+	atomic.StoreInt32(&fail, 0)
+	s.Require().Nil(s.httpBroker.ReplayDeadLetter(letter.ID), "replayed event should be acknowledged")
+	s.Require().EqualValues(4, atomic.LoadInt32(&attempts))
+	s.Require().Empty(s.httpBroker.DeadLetters())
+
+	_, rErr = s.httpBroker.DeadLetter(letter.ID)
+	s.Require().Equal(broker.ErrorNotFound, rErr)
+}
+
 func TestNats(t *testing.T) {
 	suite.Run(t, new(NatsTest))
 }
+
+func TestMemory(t *testing.T) {
+	suite.Run(t, &NatsTest{memory: true})
+}
@@ -2,6 +2,7 @@ package v2
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spike-events/spike-broker/v2/pkg/rids"
 )
@@ -57,6 +58,67 @@ func (s *serviceTestRid) CallExpectingFile() rids.Pattern {
 	return s.NewMethod("Call and get a file on response", "getFile").Get()
 }
 
+func (s *serviceTestRid) CallSlow() rids.Pattern {
+	return s.NewMethod("Call that takes a while to answer", "slow").Get()
+}
+
+func (s *serviceTestRid) CallDeadline() rids.Pattern {
+	return s.NewMethod("Returns the time left to answer", "deadline").Get()
+}
+
+func (s *serviceTestRid) CallNestedDeadline() rids.Pattern {
+	return s.NewMethod("Returns the time left to answer on a nested call", "deadline.nested").Get()
+}
+
+func (s *serviceTestRid) CallHeaders() rids.Pattern {
+	return s.NewMethod("Returns the call headers", "headers").Get()
+}
+
+func (s *serviceTestRid) CallNestedHeaders() rids.Pattern {
+	return s.NewMethod("Returns the call headers received on a nested call", "headers.nested").Get()
+}
+
+func (s *serviceTestRid) CallMiddleware() rids.Pattern {
+	return s.NewMethod("Call answered by the subscription middleware", "middleware").Get()
+}
+
+func (s *serviceTestRid) CallFlaky() rids.Pattern {
+	return s.NewMethod("Call failing every other attempt", "flaky").
+		Retry(rids.RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond}).Get()
+}
+
+func (s *serviceTestRid) CallStream() rids.Pattern {
+	return s.NewMethod("Streams the requested number of chunks", "stream").Post()
+}
+
+func (s *serviceTestRid) CallAsync() rids.Pattern {
+	return s.NewMethod("Calls TestReply twice in parallel", "async").Get()
+}
+
+func (s *serviceTestRid) CallLimited() rids.Pattern {
+	return s.NewMethod("Slow call handled one at a time", "limited").Get()
+}
+
+func (s *serviceTestRid) CallProgress() rids.Pattern {
+	return s.NewMethod("Extends its timeout and informs its progress before answering", "progress").Get()
+}
+
+func (s *serviceTestRid) CallExtended() rids.Pattern {
+	return s.NewMethod("Extends its timeout and keeps using its context past the original one", "extended").Get()
+}
+
+func (s *serviceTestRid) CallBackground() rids.Pattern {
+	return s.NewMethod("Answers and publishes event one in background", "background").Get()
+}
+
+func (s *serviceTestRid) CallInstance() rids.Pattern {
+	return s.NewMethod("Returns the key of the instance answering", "instance").Get()
+}
+
+func (s *serviceTestRid) CallGather() rids.Pattern {
+	return s.NewMethod("Returns the keys of every instance", "gather").Get()
+}
+
 func (s *serviceTestRid) EventOneTest(param ...fmt.Stringer) rids.Pattern {
 	return s.NewMethod("Event to be dispatched in test no 1", "event.$Param.one", param...).Event()
 }
@@ -64,3 +126,12 @@ func (s *serviceTestRid) EventOneTest(param ...fmt.Stringer) rids.Pattern {
 func (s *serviceTestRid) EventTwoTest(param ...fmt.Stringer) rids.Pattern {
 	return s.NewMethod("Event to be dispatched in test no 2", "event.$Param.two", param...).Event()
 }
+
+func (s *serviceTestRid) EventDurableTest(param ...fmt.Stringer) rids.Pattern {
+	return s.NewMethod("Durable event kept while no monitor is subscribed", "event.$Param.durable", param...).
+		Durable().Event()
+}
+
+func (s *serviceTestRid) EventFailingTest(param ...fmt.Stringer) rids.Pattern {
+	return s.NewMethod("Event whose monitor fails until told otherwise", "event.$Param.failing", param...).Event()
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/spike-events/spike-broker/v2/pkg/broker"
 	"github.com/spike-events/spike-broker/v2/pkg/rids"
 	"github.com/spike-events/spike-broker/v2/pkg/service"
+	spikeutils "github.com/spike-events/spike-broker/v2/pkg/spike-utils"
 	"github.com/vincent-petithory/dataurl"
 )
 
@@ -24,6 +27,7 @@ type ServiceTest struct {
 	externalAPIs map[string]interface{}
 	broker       broker.Provider
 	logger       service.Logger
+	flakyCalls   int32
 }
 
 func (s *ServiceTest) SetExternalAPI(api string, implementation interface{}) error {
@@ -84,6 +88,70 @@ func (s *ServiceTest) Handlers() []broker.Subscription {
 			Resource: ServiceTestRid().CallExpectingFile(),
 			Handler:  s.callExpectingFile,
 		},
+		{
+			Resource: ServiceTestRid().CallSlow(),
+			Handler:  s.callSlow,
+		},
+		{
+			Resource: ServiceTestRid().CallDeadline(),
+			Handler:  s.callDeadline,
+		},
+		{
+			Resource: ServiceTestRid().CallNestedDeadline(),
+			Handler:  s.callNestedDeadline,
+		},
+		{
+			Resource: ServiceTestRid().CallHeaders(),
+			Handler:  s.callHeaders,
+		},
+		{
+			Resource: ServiceTestRid().CallNestedHeaders(),
+			Handler:  s.callNestedHeaders,
+		},
+		{
+			Resource: ServiceTestRid().CallFlaky(),
+			Handler:  s.callFlaky,
+		},
+		{
+			Resource:    ServiceTestRid().CallLimited(),
+			Handler:     s.callSlow,
+			MaxInFlight: 1,
+			Overflow:    broker.OverflowReject,
+		},
+		{
+			Resource: ServiceTestRid().CallAsync(),
+			Handler:  s.callAsync,
+		},
+		{
+			Resource: ServiceTestRid().CallProgress(),
+			Handler:  s.callProgress,
+		},
+		{
+			Resource: ServiceTestRid().CallExtended(),
+			Handler:  s.callExtended,
+		},
+		{
+			Resource: ServiceTestRid().CallBackground(),
+			Handler:  s.callBackground,
+		},
+		{
+			Resource:  ServiceTestRid().CallInstance(),
+			Handler:   s.callInstance,
+			Broadcast: true,
+		},
+		{
+			Resource: ServiceTestRid().CallGather(),
+			Handler:  s.callGather,
+		},
+		{
+			Resource: ServiceTestRid().CallStream(),
+			Handler:  broker.Handle(s.callStream),
+		},
+		{
+			Resource:    ServiceTestRid().CallMiddleware(),
+			Handler:     s.callMiddleware,
+			Middlewares: []broker.CallMiddleware{s.answerFromMiddleware},
+		},
 	}
 }
 
@@ -224,6 +292,159 @@ func (s *ServiceTest) callExpectingFile(c broker.Call) {
 	c.File(data)
 }
 
+func (s *ServiceTest) callSlow(c broker.Call) {
+	time.Sleep(500 * time.Millisecond)
+	c.OK()
+}
+
+func (s *ServiceTest) callDeadline(c broker.Call) {
+	deadline, ok := c.Context().Deadline()
+	if !ok {
+		c.Error(broker.ErrorInvalidParams)
+		return
+	}
+	c.OK(time.Until(deadline))
+}
+
+func (s *ServiceTest) callNestedDeadline(c broker.Call) {
+	var left time.Duration
+	err := c.Provider().Get(ServiceTestRid().CallDeadline(), &left, c.RawToken())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.OK(left)
+}
+
+func (s *ServiceTest) callHeaders(c broker.Call) {
+	c.OK(c.Headers())
+}
+
+func (s *ServiceTest) callNestedHeaders(c broker.Call) {
+	var headers map[string]string
+	err := c.Provider().Get(ServiceTestRid().CallHeaders(), &headers, c.RawToken())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.OK(headers)
+}
+
+func (s *ServiceTest) callFlaky(c broker.Call) {
+	if calls := atomic.AddInt32(&s.flakyCalls, 1); calls%2 == 1 {
+		c.Error(broker.ErrorServiceUnavailable)
+		return
+	}
+	c.OK()
+}
+
+func (s *ServiceTest) callProgress(c broker.Call) {
+	c.Timeout(time.Second)
+	for step := 1; step <= 2; step++ {
+		time.Sleep(150 * time.Millisecond)
+		c.Progress(step)
+	}
+	c.OK(map[string]string{"status": "done"})
+}
+
+func (s *ServiceTest) callExtended(c broker.Call) {
+	c.Timeout(time.Second)
+	time.Sleep(300 * time.Millisecond)
+	if err := c.Context().Err(); err != nil {
+		c.Error(broker.ErrorFromContext(c.Context()))
+		return
+	}
+	var left time.Duration
+	if err := c.Provider().Get(ServiceTestRid().CallDeadline(), &left, c.RawToken()); err != nil {
+		c.Error(err)
+		return
+	}
+	c.OK(left)
+}
+
+func (s *ServiceTest) callBackground(c broker.Call) {
+	c.OK()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		p := ServiceTestRid().EventOneTest(spikeutils.Stringer("backgroundValue"))
+		if err := c.Provider().Publish(p, "background", c.RawToken()); err != nil {
+			s.logger.Printf("failed to publish in background: %v", err)
+		}
+	}()
+}
+
+func (s *ServiceTest) callAsync(c broker.Call) {
+	ids := make([]uuid.UUID, 2)
+	futures := make([]broker.Future, len(ids))
+	for i := range ids {
+		id, _ := uuid.NewV4()
+		futures[i] = s.Broker().RequestAsync(c.Context(), ServiceTestRid().TestReply(id), id, &ids[i], c.RawToken())
+	}
+	if err := broker.WaitAll(c.Context(), time.Second, futures...); err != nil {
+		c.Error(err)
+		return
+	}
+	c.OK(ids)
+}
+
+func (s *ServiceTest) callInstance(c broker.Call) {
+	c.OK(s.key.String())
+}
+
+func (s *ServiceTest) callGather(c broker.Call) {
+	replies, rErr := s.Broker().RequestAll(c.Context(), ServiceTestRid().CallInstance(), nil,
+		broker.RequestAllOptions{Timeout: 200 * time.Millisecond}, c.RawToken())
+	if rErr != nil {
+		c.Error(rErr)
+		return
+	}
+
+	keys := make([]string, 0, len(replies))
+	for _, reply := range replies {
+		if reply.Err != nil {
+			c.Error(reply.Err)
+			return
+		}
+		keys = append(keys, string(reply.Data))
+	}
+	c.OK(keys)
+}
+
+// StreamRequest sets how many chunks CallStream sends and whether it fails after them
+type StreamRequest struct {
+	Count int  `json:"count"`
+	Fail  bool `json:"fail"`
+}
+
+type StreamChunk struct {
+	Index int `json:"index"`
+}
+
+func (s *ServiceTest) callStream(c broker.Call, req StreamRequest) {
+	stream := c.Stream()
+	for i := 0; i < req.Count; i++ {
+		if err := stream.Send(&StreamChunk{Index: i}); err != nil {
+			stream.Close(err)
+			return
+		}
+	}
+	if req.Fail {
+		stream.Close(broker.ErrorNotFound)
+		return
+	}
+	stream.Close()
+}
+
+func (s *ServiceTest) callMiddleware(c broker.Call) {
+	c.OK(map[string]string{"answeredBy": "handler"})
+}
+
+func (s *ServiceTest) answerFromMiddleware(next broker.CallHandler) broker.CallHandler {
+	return func(c broker.Call) {
+		c.OK(map[string]string{"answeredBy": "middleware"})
+	}
+}
+
 func (s *ServiceTest) handleEventOne(c broker.Call) {
 	s.logger.Printf("event one called")
 	c.OK()
@@ -1,26 +1,39 @@
 package v2
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"log"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/spike-events/spike-broker/v2/pkg/broker"
+	"github.com/spike-events/spike-broker/v2/pkg/broker/dedup"
 	"github.com/spike-events/spike-broker/v2/pkg/broker/providers/testProvider"
 	"github.com/spike-events/spike-broker/v2/pkg/spike"
+	"github.com/spike-events/spike-broker/v2/pkg/spike/socket"
 	"github.com/stretchr/testify/suite"
 	"github.com/vincent-petithory/dataurl"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 type UnitTest struct {
 	suite.Suite
-	id  uuid.UUID
-	ctx context.Context
-	svc spike.APITestService
+	id              uuid.UUID
+	ctx             context.Context
+	svc             spike.APITestService
+	middlewareCalls int
+	pipeline        []string
 }
 
 func (u *UnitTest) TearDownSuite() {
@@ -43,6 +56,7 @@ func (u *UnitTest) SetupSuite() {
 
 	// Test Authenticator (validates token)
 	authenticator := spike.NewTestAuthenticator(func(s []byte) ([]byte, bool) {
+		u.pipeline = append(u.pipeline, "authenticate")
 		return s, true
 	})
 
@@ -61,6 +75,15 @@ func (u *UnitTest) SetupSuite() {
 		Authenticator: authenticator,
 		Authorizer:    authorizer,
 		Timeout:       2 * time.Minute,
+		Middlewares: []broker.CallMiddleware{
+			func(next broker.CallHandler) broker.CallHandler {
+				return func(c broker.Call) {
+					u.middlewareCalls++
+					u.pipeline = append(u.pipeline, "middleware")
+					next(c)
+				}
+			},
+		},
 	})
 	if err != nil {
 		u.FailNow("failed to initialize the API Service:", err)
@@ -143,6 +166,99 @@ func (u *UnitTest) TestWithObjectPayload() {
 	u.Require().Nil(err, "Should have returned success")
 }
 
+func (u *UnitTest) TestMiddlewares() {
+	calls := u.middlewareCalls
+	t := spike.APITestRequestOrPublish{
+		Pattern: ServiceTestRid().CallMiddleware(),
+		Ok: func(i ...interface{}) {
+			u.Require().NotEmpty(i, "should have a return value")
+			u.Require().Equal(map[string]string{"answeredBy": "middleware"}, i[0],
+				"middleware should have answered the call")
+		},
+		Err: func(i interface{}) {
+			u.FailNow("Should have succeeded")
+		},
+		Mocks: testProvider.Mocks{},
+	}
+	err := u.svc.TestRequestOrPublish(t)
+	u.Require().Nil(err, "Should have returned success")
+	u.Require().Equal(calls+1, u.middlewareCalls, "options middleware should have run")
+}
+
+func (u *UnitTest) TestMiddlewaresOrder() {
+	u.pipeline = nil
+	t := spike.APITestAccess{
+		Pattern: ServiceTestRid().CallMiddleware(),
+		Token:   []byte("token-string"),
+		Ok: func() {
+			u.pipeline = append(u.pipeline, "handler")
+		},
+		Err: func(i int, value interface{}) {
+			u.FailNow("should have been authorized", value)
+		},
+	}
+	err := u.svc.TestAccess(t)
+	u.Require().Nil(err, "Should have returned success")
+	u.Require().Equal([]string{"middleware", "authenticate", "handler"}, u.pipeline,
+		"options middlewares should run before authentication")
+}
+
+func (u *UnitTest) TestRequestAll() {
+	instance := func(key string) testProvider.RequestMock {
+		return func(c broker.Call) {
+			c.OK(key)
+		}
+	}
+
+	t := spike.APITestRequestOrPublish{
+		Pattern: ServiceTestRid().CallGather(),
+		Ok: func(i ...interface{}) {
+			u.Require().NotEmpty(i, "should have a return value")
+			u.Require().Equal([]string{"one", "two"}, i[0], "every mocked instance should have answered")
+		},
+		Err: func(i interface{}) {
+			u.FailNow("Should have succeeded")
+		},
+		Mocks: testProvider.Mocks{
+			Broadcasts: map[string][]testProvider.RequestMock{
+				ServiceTestRid().CallInstance().EndpointName(): {instance("one"), instance("two")},
+			},
+		},
+	}
+	err := u.svc.TestRequestOrPublish(t)
+	u.Require().Nil(err, "Should have returned success")
+}
+
+func (u *UnitTest) TestRequestAsync() {
+	testReplyMock := func(c broker.Call) {
+		var id uuid.UUID
+		err := json.Unmarshal(c.RawData(), &id)
+		u.Require().Nil(err, "invalid payload providaded")
+		c.OK(id)
+	}
+
+	t := spike.APITestRequestOrPublish{
+		Pattern: ServiceTestRid().CallAsync(),
+		Ok: func(i ...interface{}) {
+			u.Require().NotEmpty(i, "should have a return value")
+			ids, valid := i[0].([]uuid.UUID)
+			u.Require().True(valid, "value is not a uuid list")
+			u.Require().Len(ids, 2, "both futures should have been answered")
+			u.Require().NotEqual(ids[0], ids[1], "futures mixed their responses")
+		},
+		Err: func(i interface{}) {
+			u.FailNow("Should have succeeded")
+		},
+		Mocks: testProvider.Mocks{
+			Requests: map[string]testProvider.RequestMock{
+				ServiceTestRid().TestReply().EndpointName(): testReplyMock,
+			},
+		},
+	}
+	err := u.svc.TestRequestOrPublish(t)
+	u.Require().Nil(err, "Should have returned success")
+}
+
 func (u *UnitTest) TestExpectingFile() {
 	t := spike.APITestRequestOrPublish{
 		Pattern:    ServiceTestRid().CallExpectingFile(),
@@ -170,6 +286,94 @@ func (u *UnitTest) TestExpectingFile() {
 	u.Require().Nil(err, "Should have returned success")
 }
 
+func (u *UnitTest) TestDedupMiddleware() {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(u.T().TempDir(), "dedup.db")), &gorm.Config{})
+	u.Require().Nil(err, "failed to open dedup database")
+	gormStore, err := dedup.NewGormStore(db, 1)
+	u.Require().Nil(err, "failed to create gorm store")
+
+	for name, store := range map[string]dedup.Store{"memory": dedup.NewMemoryStore(1), "gorm": gormStore} {
+		handled := 0
+		fail := false
+		handler := dedup.Middleware(store)(func(c broker.Call) {
+			handled++
+			if fail {
+				c.Error(broker.ErrorServiceUnavailable)
+				return
+			}
+			c.OK()
+		})
+		deliverTo := func(group, key string) {
+			c := testProvider.NewCall(ServiceTestRid().EventTwoTest(), nil, nil, nil, nil, nil)
+			c.SetHeaders(map[string]string{broker.HeaderIdempotencyKey: key})
+			c.SetContext(broker.WithMonitoringGroup(c.Context(), group))
+			handler(c)
+		}
+		deliver := func(key string) {
+			deliverTo("", key)
+		}
+
+		fail = true
+		deliver("first")
+		fail = false
+		deliver("first")
+		deliver("first")
+		u.Require().Equal(2, handled, "%s: failed events must be handled again, processed ones skipped", name)
+
+		// The store keeps one key, so the first one is forgotten
+		deliver("second")
+		deliver("first")
+		u.Require().Equal(4, handled, "%s: keys over the store size must be discarded", name)
+
+		// Keys are claimed by each monitoring group
+		deliverTo("other", "first")
+		u.Require().Equal(5, handled, "%s: keys must be scoped by the monitoring group", name)
+
+		// Concurrent deliveries of an event claim its key once
+		var claims int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if claimed, _ := store.Claim("concurrent"); claimed {
+					atomic.AddInt32(&claims, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		u.Require().EqualValues(1, claims, "%s: a key must be claimed once", name)
+	}
+}
+
+func (u *UnitTest) TestRequestHeadersLimits() {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Tenant", "tenant")
+	r.Header.Set("X-Large", strings.Repeat("a", socket.MaxHeaderValueLength+1))
+	r.Header.Set(broker.HeaderCallerService, "spoofed")
+	r.Header.Set(broker.HeaderIdempotencyKey, "spoofed")
+
+	headers := socket.RequestHeaders(r, "X-Tenant", "X-Large", broker.HeaderCallerService,
+		strings.ToUpper(broker.HeaderIdempotencyKey))
+	u.Require().Equal("tenant", headers["X-Tenant"])
+	u.Require().NotContains(headers, "X-Large", "large values should be skipped")
+	u.Require().NotContains(headers, broker.HeaderCallerService, "reserved headers should not be forwarded")
+	u.Require().NotContains(headers, strings.ToUpper(broker.HeaderIdempotencyKey),
+		"reserved headers should be matched regardless of case")
+}
+
+func (u *UnitTest) TestDecompressionLimit() {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, err := w.Write(make([]byte, broker.MaxDecompressedSize+1))
+	u.Require().Nil(err)
+	u.Require().Nil(w.Close())
+
+	envelope := append([]byte("\x00spike;compression=gzip\n"), compressed.Bytes()...)
+	_, err = broker.NewCallFromJSON(envelope, ServiceTestRid().RootEP(), "")
+	u.Require().Equal(broker.ErrorDecompressedTooLarge, err, "envelope should have been rejected")
+}
+
 func TestUnit(t *testing.T) {
 	suite.Run(t, new(UnitTest))
 }
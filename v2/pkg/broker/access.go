@@ -40,7 +40,9 @@ func NewAccess(callMsg Call) Access {
 				ReplyStr:        callMsg.Reply(),
 				EndpointPattern: callMsg.Endpoint(),
 				Token:           callMsg.RawToken(),
+				HeadersMap:      callMsg.Headers(),
 				provider:        callMsg.Provider(),
+				ctx:             callMsg.Context(),
 			},
 			APIVersion: 2,
 		},
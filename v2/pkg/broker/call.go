@@ -1,8 +1,10 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hetiansu5/urlquery"
@@ -13,6 +15,10 @@ import (
 
 type CallHandler func(c Call)
 
+// CallMiddleware wraps a CallHandler to run code before and after it. A middleware may answer the Call itself and not
+// call next to stop the pipeline
+type CallMiddleware func(next CallHandler) CallHandler
+
 type Call interface {
 	RawToken() []byte
 	RawData() []byte
@@ -26,14 +32,35 @@ type Call interface {
 	ToJSON() json.RawMessage
 	Timeout(timeout time.Duration)
 
+	// Progress informs the caller the handler is still running, sending data to the WithProgress handler of its
+	// context. It also restarts the caller wait. Callers not sending HeaderAcceptControl do not receive it
+	Progress(data interface{})
+
 	File(f *dataurl.DataURL)
 	OK(result ...interface{})
 
+	// Stream replies in chunks instead of calling OK, File or Error. Callers that do not read streams receive the
+	// chunks at once as a JSON array
+	Stream() Stream
+
 	GetError() Error
 	Error(err error, msg ...string)
 
 	SetToken(token []byte)
 	SetProvider(provider Provider)
+
+	// Headers returns the metadata sent along with the Call, like the request ID or the client IP
+	Headers() map[string]string
+	SetHeaders(headers map[string]string)
+
+	// Context returns the context of the Call. Its deadline is the remaining time the caller waits for the response,
+	// Timeout extends it and it is cancelled once the handler returns or the APIService stops. Requests made through
+	// Provider are not cancelled when the handler returns
+	Context() context.Context
+
+	// SetContext binds the Call to ctx applying the deadline received from the caller. The returned function releases
+	// the context resources and must be called once the Call has been handled
+	SetContext(ctx context.Context) context.CancelFunc
 }
 
 // NewCall returns a Call interface instance
@@ -62,6 +89,43 @@ func NewCall(p rids.Pattern, data interface{}) Call {
 }
 
 func NewCallFromJSON(callJSON json.RawMessage, p rids.Pattern, reply string) (Call, error) {
+	// Monitors with acknowledgements receive an endpoint recording their result, their Calls keep the empty reply of
+	// events
+	var ack string
+	if strings.HasPrefix(reply, ackPrefix) {
+		ack, reply = reply, ""
+	}
+	c, err := newCallFromJSON(callJSON, p, reply)
+	if err == nil && ack != "" {
+		if acked, ok := c.(interface{ setAck(ack string) }); ok {
+			acked.setAck(ack)
+		}
+	}
+	return c, err
+}
+
+func newCallFromJSON(callJSON json.RawMessage, p rids.Pattern, reply string) (Call, error) {
+	if params, body, marked := decodeFrame(callJSON); marked {
+		body, err := decompressEnvelope(params, body)
+		if err != nil {
+			return nil, err
+		}
+		codec, err := frameCodec(params)
+		if err != nil {
+			return nil, err
+		}
+		if !isLegacyCodec(codec) {
+			c, err := decodeCall(codec, body)
+			if err != nil {
+				return nil, err
+			}
+			c.ReplyStr = reply
+			c.readAcceptHeaders()
+			return c, nil
+		}
+		callJSON = body
+	}
+
 	type apiVersion struct {
 		APIVersion int `json:"apiVersion"`
 	}
@@ -84,6 +148,7 @@ func NewCallFromJSON(callJSON json.RawMessage, p rids.Pattern, reply string) (Ca
 		return nil, err
 	}
 	c.ReplyStr = reply
+	c.readAcceptHeaders()
 	return &c, nil
 }
 
@@ -103,12 +168,14 @@ type call struct {
 
 func (c *call) UnmarshalJSON(data []byte) error {
 	type callInnerType struct {
-		Data            RawData         `json:"data"`
-		ReplyStr        string          `json:"reply"`
-		EndpointPattern json.RawMessage `json:"endpointPattern"`
-		Token           RawData         `json:"token"`
-		TokenV1         string          `json:"Token"`
-		APIVersion      int             `json:"apiVersion"`
+		Data            RawData           `json:"data"`
+		ReplyStr        string            `json:"reply"`
+		EndpointPattern json.RawMessage   `json:"endpointPattern"`
+		Token           RawData           `json:"token"`
+		TokenV1         string            `json:"Token"`
+		TimeoutBudget   time.Duration     `json:"timeoutBudget"`
+		HeadersMap      map[string]string `json:"headers"`
+		APIVersion      int               `json:"apiVersion"`
 	}
 	var callInner callInnerType
 	err := json.Unmarshal(data, &callInner)
@@ -124,10 +191,26 @@ func (c *call) UnmarshalJSON(data []byte) error {
 	c.ReplyStr = callInner.ReplyStr
 	c.EndpointPattern = pattern
 	c.Token = callInner.Token
+	c.TimeoutBudget = callInner.TimeoutBudget
+	c.HeadersMap = callInner.HeadersMap
+	if c.TimeoutBudget > 0 {
+		c.deadline = time.Now().Add(c.TimeoutBudget)
+	}
 	c.APIVersion = 2
 	return nil
 }
 
+func (c *call) Stream() Stream {
+	if c.stream == nil {
+		if c.streaming && c.ReplyStr != "" {
+			c.stream = &callStream{c: &c.callBase}
+		} else {
+			c.stream = NewBufferedStream(c)
+		}
+	}
+	return c.stream
+}
+
 func (c *call) ToJSON() json.RawMessage {
 	type callV1Compatible struct {
 		call
@@ -1,9 +1,11 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/hetiansu5/urlquery"
@@ -13,20 +15,111 @@ import (
 )
 
 type callBase struct {
-	Data            RawData      `json:"Data"` // As V1 uses upper case on JSON notation we keep data this way
-	ReplyStr        string       `json:"reply"`
-	EndpointPattern rids.Pattern `json:"endpointPattern"`
-	Token           RawData      `json:"token"`
+	Data            RawData           `json:"Data"` // As V1 uses upper case on JSON notation we keep data this way
+	ReplyStr        string            `json:"reply"`
+	EndpointPattern rids.Pattern      `json:"endpointPattern"`
+	Token           RawData           `json:"token"`
+	TimeoutBudget   time.Duration     `json:"timeoutBudget,omitempty"`
+	HeadersMap      map[string]string `json:"headers,omitempty"`
 	provider        Provider
 	err             Error
+	deadline        time.Time
+	ctx             context.Context
+	codec           Codec
+	compression     *CompressionOptions
+	streaming       bool
+	stream          Stream
+	control         bool
+	ack             string
 }
 
 func (c *callBase) Endpoint() rids.Pattern {
 	return c.EndpointPattern
 }
 
+// Provider returns the Provider bound to the Call context, so requests made through it inherit the Call deadline.
+// They are not cancelled when the handler returns, so handlers may answer and keep working in background
 func (c *callBase) Provider() Provider {
-	return c.provider
+	if c.ctx == nil || c.provider == nil {
+		return c.provider
+	}
+	return &contextProvider{
+		Provider: c.provider,
+		ctx:      c.ctx,
+	}
+}
+
+func (c *callBase) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+func (c *callBase) Headers() map[string]string {
+	return c.HeadersMap
+}
+
+func (c *callBase) SetHeaders(headers map[string]string) {
+	c.HeadersMap = headers
+}
+
+// SetContext binds the Call to ctx. The received headers are carried by the Call context so they are sent again on
+// nested requests, headers already on ctx take precedence. The idempotency key identifies the received event only.
+// Contexts derived from the Call context, like the handler span, are bound as they are so Timeout keeps extending
+// the deadline already applied, and releasing them is left to the first binding
+func (c *callBase) SetContext(ctx context.Context) context.CancelFunc {
+	headers := mergeHeaders(c.HeadersMap, HeadersFromContext(ctx))
+	delete(headers, HeaderIdempotencyKey)
+	ctx = context.WithValue(ctx, headersKey{}, headers)
+	if bound := callContextFrom(c.ctx); bound != nil && bound == callContextFrom(ctx) {
+		c.ctx = ctx
+		return func() {}
+	}
+	callCtx, cancel := newCallContext(ctx, c.deadline)
+	c.ctx = callCtx
+	return cancel
+}
+
+// readAcceptHeaders sets the codec, compression, streaming and control frames of the replies from the headers sent by the caller. The headers are
+// removed so they are not forwarded to nested calls
+func (c *callBase) readAcceptHeaders() {
+	if accept, ok := c.HeadersMap[HeaderAcceptCodec]; ok {
+		if codec := CodecByName(accept); codec != nil {
+			c.codec = codec
+		}
+		delete(c.HeadersMap, HeaderAcceptCodec)
+	}
+	if accept, ok := c.HeadersMap[HeaderAcceptCompression]; ok {
+		c.compression = parseAcceptCompression(accept)
+		delete(c.HeadersMap, HeaderAcceptCompression)
+	}
+	if _, ok := c.HeadersMap[HeaderAcceptStream]; ok {
+		c.streaming = true
+		delete(c.HeadersMap, HeaderAcceptStream)
+	}
+	if accept, ok := c.HeadersMap[HeaderAcceptControl]; ok {
+		version, _ := strconv.Atoi(accept)
+		c.control = version >= ControlProtocol
+		delete(c.HeadersMap, HeaderAcceptControl)
+	}
+}
+
+// reply sends an encoded envelope to the caller. When the caller accepts compression the envelope is always marked,
+// letting the caller know compressed requests are understood
+func (c *callBase) reply(data []byte) {
+	if c.compression != nil {
+		data, _ = compressEnvelope(c.compression, "reply", data)
+		if _, _, marked := decodeFrame(data); !marked {
+			data = encodeFrame(nil, data)
+		}
+	}
+	c.provider.Reply(c.ReplyStr, data) // FIXME: Log or return error
+}
+
+// setTimeoutBudget informs the handler how long the caller will wait for the response
+func (c *callBase) setTimeoutBudget(budget time.Duration) {
+	c.TimeoutBudget = budget
 }
 
 func (c *callBase) Reply() string {
@@ -82,18 +175,11 @@ func (c *callBase) File(f *dataurl.DataURL) {
 		return
 	}
 
-	var success Message
-	success.CodeInt = http.StatusOK
 	payload, err := json.Marshal(f)
 	if err != nil {
 		panic(err)
 	}
-	success.DataIface = payload
-	data, err := json.Marshal(&success)
-	if err != nil {
-		panic(err)
-	}
-	err = c.provider.Reply(c.ReplyStr, data)
+	c.reply(encodeReply(c.codec, http.StatusOK, payload, ""))
 }
 
 func (c *callBase) OK(result ...interface{}) {
@@ -101,49 +187,24 @@ func (c *callBase) OK(result ...interface{}) {
 		return
 	}
 
-	var success Message
-	success.CodeInt = http.StatusOK
-
-	if len(result) == 0 {
-		data, err := json.Marshal(&success)
-		if err != nil {
-			panic(err)
+	var data []byte
+	if len(result) > 0 {
+		switch result[0].(type) {
+		case string:
+			data = []byte(result[0].(string))
+		case []byte:
+			data = result[0].([]byte)
+		default:
+			// Make sure we always marshal pointer structures
+			result[0] = spikeutils.PointerFromInterface(result[0])
+			encoded, err := json.Marshal(result[0])
+			if err != nil {
+				panic(err)
+			}
+			data = encoded
 		}
-		c.provider.Reply(c.ReplyStr, data) // FIXME: Log or return error
-		return
 	}
-
-	switch result[0].(type) {
-	case string:
-		success.DataIface = []byte(result[0].(string))
-		data, err := json.Marshal(&success)
-		if err != nil {
-			panic(err)
-		}
-		c.provider.Reply(c.ReplyStr, data) // FIXME: Log or return error
-		return
-	case []byte:
-		success.DataIface = result[0].([]byte)
-		data, err := json.Marshal(&success)
-		if err != nil {
-			panic(err)
-		}
-		c.provider.Reply(c.ReplyStr, data) // FIXME: Log or return error
-		return
-	}
-
-	// Make sure we always marshal pointer structures
-	result[0] = spikeutils.PointerFromInterface(result[0])
-	encoded, err := json.Marshal(result[0])
-	if err != nil {
-		panic(err)
-	}
-	success.DataIface = encoded
-	data, err := json.Marshal(&success)
-	if err != nil {
-		panic(err)
-	}
-	c.provider.Reply(c.ReplyStr, data) // FIXME: Log or return error
+	c.reply(encodeReply(c.codec, http.StatusOK, data, ""))
 }
 
 func (c *callBase) GetError() Error {
@@ -176,16 +237,57 @@ func (c *callBase) Timeout(timeout time.Duration) {
 		timeout = 100 * time.Millisecond
 	}
 
+	if callCtx := callContextFrom(c.ctx); callCtx != nil {
+		callCtx.extend(timeout)
+	}
+
+	if c.control {
+		c.provider.Reply(c.ReplyStr, encodeControl(Control{Kind: ControlExtend, Timeout: timeout}))
+		return
+	}
 	c.provider.Reply(c.ReplyStr, []byte(fmt.Sprintf("timeout:%d", int(timeout)))) // FIXME: Log or return error
 }
 
+func (c *callBase) Progress(data interface{}) {
+	if c.ReplyStr == "" || !c.control {
+		return
+	}
+
+	var encoded []byte
+	switch value := data.(type) {
+	case nil:
+	case []byte:
+		encoded = value
+	default:
+		var err error
+		if encoded, err = json.Marshal(spikeutils.PointerFromInterface(value)); err != nil {
+			panic(err)
+		}
+	}
+	c.provider.Reply(c.ReplyStr, encodeControl(Control{Kind: ControlProgress, Data: encoded}))
+}
+
+// setAck sets the endpoint recording the result of a monitor handler
+func (c *callBase) setAck(ack string) {
+	c.ack = ack
+}
+
 // Error result
 func (c *callBase) error(err Error) {
+	c.err = err
+	if c.ack != "" && c.provider != nil {
+		// Rejects the event on the monitor acknowledgement
+		c.provider.Reply(c.ack, encodeReply(c.codec, err.Code(), err.Data(), err.Error()))
+	}
 	if c.ReplyStr == "" {
 		return
 	}
 
-	c.provider.Reply(c.ReplyStr, err.ToJSON()) // FIXME: Log or return error
+	if isLegacyCodec(c.codec) {
+		c.reply(err.ToJSON())
+		return
+	}
+	c.reply(encodeReply(c.codec, err.Code(), err.Data(), err.Error()))
 }
 
 func (c *callBase) ParseQuery(q interface{}) error {
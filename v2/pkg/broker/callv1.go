@@ -70,6 +70,14 @@ func NewCallFromV1(r *request.CallRequest) (Call, error) {
 	return &v1, nil
 }
 
+// Stream always buffers the chunks as V1 callers do not read streams
+func (c *callV1) Stream() Stream {
+	if c.stream == nil {
+		c.stream = NewBufferedStream(c)
+	}
+	return c.stream
+}
+
 func (c *callV1) ToJSON() json.RawMessage {
 	data, err := json.Marshal(c)
 	if err != nil {
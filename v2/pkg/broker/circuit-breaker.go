@@ -0,0 +1,194 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+)
+
+// CircuitBreakerOptions configures the circuit breaker kept for each endpoint name. The circuit opens after Failures
+// failures or timeouts within Window, failing fast with ErrorServiceUnavailable. After OpenTimeout a single probe
+// request is let through: the circuit closes if it succeeds and opens again otherwise
+type CircuitBreakerOptions struct {
+	// Failures is the number of failures that opens the circuit, defaults to 5
+	Failures int
+
+	// Window is the period in which failures are counted, defaults to 10s
+	Window time.Duration
+
+	// OpenTimeout is how long the circuit stays open before probing the endpoint, defaults to 30s
+	OpenTimeout time.Duration
+}
+
+// CircuitState is the state of an endpoint circuit breaker
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "halfOpen"
+)
+
+// CircuitBreakerEvent is published on rids.Spike().EventCircuitBreakerChanged when a circuit changes state
+type CircuitBreakerEvent struct {
+	Endpoint string       `json:"endpoint"`
+	State    CircuitState `json:"state"`
+	Failures int          `json:"failures"`
+}
+
+type circuitBreaker struct {
+	opts     CircuitBreakerOptions
+	m        sync.Mutex
+	state    CircuitState
+	failures []time.Time
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(opts CircuitBreakerOptions) *circuitBreaker {
+	if opts.Failures <= 0 {
+		opts.Failures = 5
+	}
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	return &circuitBreaker{
+		opts:  opts,
+		state: CircuitClosed,
+	}
+}
+
+// allow reports if a request can be sent, moving an open circuit to half-open once OpenTimeout has passed
+func (b *circuitBreaker) allow() (bool, bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.opts.OpenTimeout {
+			return false, false
+		}
+		b.state = CircuitHalfOpen
+		b.probing = true
+		return true, true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, false
+	}
+	return true, false
+}
+
+// record accounts the request result and reports if the circuit changed state
+func (b *circuitBreaker) record(rErr Error) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if !isCircuitFailure(rErr) {
+		b.failures = b.failures[:0]
+		if b.state == CircuitHalfOpen {
+			b.state = CircuitClosed
+			b.probing = false
+			return true
+		}
+		return false
+	}
+
+	now := time.Now()
+	if b.state == CircuitHalfOpen {
+		b.state = CircuitOpen
+		b.openedAt = now
+		b.probing = false
+		return true
+	}
+
+	recent := b.failures[:0]
+	for _, failure := range b.failures {
+		if now.Sub(failure) < b.opts.Window {
+			recent = append(recent, failure)
+		}
+	}
+	b.failures = append(recent, now)
+	if b.state == CircuitClosed && len(b.failures) >= b.opts.Failures {
+		b.state = CircuitOpen
+		b.openedAt = now
+		return true
+	}
+	return false
+}
+
+// abandon releases the probe slot of a request that ended without a result
+func (b *circuitBreaker) abandon() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) event(endpoint string) CircuitBreakerEvent {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return CircuitBreakerEvent{
+		Endpoint: endpoint,
+		State:    b.state,
+		Failures: len(b.failures),
+	}
+}
+
+// isCircuitFailure reports if rErr means the endpoint is unhealthy. Errors answered by the handler, like validation
+// or permission errors, and requests cancelled by the caller do not count
+func isCircuitFailure(rErr Error) bool {
+	if rErr == nil {
+		return false
+	}
+	return rErr.Code() == http.StatusRequestTimeout || rErr.Code() >= http.StatusInternalServerError
+}
+
+// guard runs request through the circuit breaker of p when enabled
+func (s *specificProviderBase) guard(ctx context.Context, p rids.Pattern, request func() Error) Error {
+	if s.opts.CircuitBreaker == nil {
+		return request()
+	}
+
+	endpoint := p.EndpointName()
+	s.breakersM.Lock()
+	if s.breakers == nil {
+		s.breakers = make(map[string]*circuitBreaker)
+	}
+	breaker, ok := s.breakers[endpoint]
+	if !ok {
+		breaker = newCircuitBreaker(*s.opts.CircuitBreaker)
+		s.breakers[endpoint] = breaker
+	}
+	s.breakersM.Unlock()
+
+	allowed, changed := breaker.allow()
+	if changed {
+		s.publishCircuitState(endpoint, breaker)
+	}
+	if !allowed {
+		return ErrorServiceUnavailable
+	}
+
+	rErr := request()
+	if ctxErr := ErrorFromContext(ctx); ctxErr != nil && rErr != nil && rErr.Code() == ctxErr.Code() {
+		// The caller gave up, it says nothing about the endpoint health
+		breaker.abandon()
+		return rErr
+	}
+	if breaker.record(rErr) {
+		s.publishCircuitState(endpoint, breaker)
+	}
+	return rErr
+}
+
+func (s *specificProviderBase) publishCircuitState(endpoint string, breaker *circuitBreaker) {
+	event := breaker.event(endpoint)
+	s.sendEvent(context.Background(), rids.Spike().EventCircuitBreakerChanged(), event)
+}
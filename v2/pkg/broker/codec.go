@@ -0,0 +1,88 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes the Call and reply envelopes sent through the Provider. Payloads keep their JSON encoding so
+// handlers and the HTTP server are not affected by the codec in use
+type Codec interface {
+	// Name identifies the codec on the envelope marker
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec is the default codec, producing the envelopes understood by every Spike version
+	JSONCodec Codec = jsonCodec{}
+
+	// MessagePackCodec sends binary envelopes, avoiding the base64 encoding of payloads
+	MessagePackCodec Codec = msgpackCodec{}
+)
+
+var codecs = map[string]Codec{
+	JSONCodec.Name():        JSONCodec,
+	MessagePackCodec.Name(): MessagePackCodec,
+}
+var codecsM sync.RWMutex
+
+// RegisterCodec makes codec available to decode received envelopes. JSONCodec and MessagePackCodec are always
+// registered
+func RegisterCodec(codec Codec) {
+	codecsM.Lock()
+	defer codecsM.Unlock()
+	codecs[codec.Name()] = codec
+}
+
+// CodecByName returns the registered codec named name or nil when it is unknown
+func CodecByName(name string) Codec {
+	codecsM.RLock()
+	defer codecsM.RUnlock()
+	return codecs[name]
+}
+
+// isLegacyCodec reports if codec produces the unmarked JSON envelopes
+func isLegacyCodec(codec Codec) bool {
+	return codec == nil || codec.Name() == JSONCodec.Name()
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
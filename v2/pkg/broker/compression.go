@@ -0,0 +1,203 @@
+package broker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// HeaderAcceptCompression is sent on calls to inform the handler the caller understands compressed replies. Its value
+// is the algorithm followed by the minimum size to compress, like "gzip;min=1024"
+const HeaderAcceptCompression = "acceptCompression"
+
+// Compression is a compression algorithm applied to the envelopes
+type Compression string
+
+const (
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// CompressionOptions configures the compression of envelopes larger than MinSize. Requests are only compressed once
+// the service answered with a marked envelope, proving it can decompress them, and replies are compressed by the
+// handler when the caller accepts it
+type CompressionOptions struct {
+	// Algorithm defaults to gzip
+	Algorithm Compression
+
+	// MinSize is the envelope size in bytes from which it is compressed, defaults to 1KB
+	MinSize int
+
+	// Events enables the compression of published events. Enable it only when every subscriber runs a Spike version
+	// supporting compression
+	Events bool
+}
+
+func (o *CompressionOptions) algorithm() Compression {
+	if o.Algorithm == "" {
+		return CompressionGzip
+	}
+	return o.Algorithm
+}
+
+func (o *CompressionOptions) minSize() int {
+	if o.MinSize <= 0 {
+		return 1024
+	}
+	return o.MinSize
+}
+
+// acceptHeader returns the HeaderAcceptCompression value for the options
+func (o *CompressionOptions) acceptHeader() string {
+	return fmt.Sprintf("%s;min=%d", o.algorithm(), o.minSize())
+}
+
+// parseAcceptCompression reads a HeaderAcceptCompression value, returning nil when it is invalid
+func parseAcceptCompression(value string) *CompressionOptions {
+	algorithm, min, _ := strings.Cut(value, ";min=")
+	switch Compression(algorithm) {
+	case CompressionGzip, CompressionZstd:
+	default:
+		return nil
+	}
+	minSize, _ := strconv.Atoi(min)
+	return &CompressionOptions{
+		Algorithm: Compression(algorithm),
+		MinSize:   minSize,
+	}
+}
+
+// MaxDecompressedSize limits the size of a decompressed envelope, so a small crafted envelope cannot expand to a huge
+// payload inside the subscribers. Larger envelopes are rejected with ErrorDecompressedTooLarge, so they are sent
+// without compression, up to the transport limit. Defaults to 8MB
+var MaxDecompressedSize int64 = 8 << 20
+
+// ErrorDecompressedTooLarge is returned for the envelopes exceeding MaxDecompressedSize once decompressed
+var ErrorDecompressedTooLarge = NewInvalidParamsError("decompressed envelope too large")
+
+// The gzip writers and readers and the zstd decoders are reused between envelopes. The zstd encoder is shared, as
+// EncodeAll can be called concurrently
+var (
+	gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	gzipReaders sync.Pool
+
+	zstdEncoder     *zstd.Encoder
+	zstdEncoderErr  error
+	zstdEncoderOnce sync.Once
+
+	// Decoders with concurrency 1 do not start goroutines, so the ones dropped by the pool are simply collected
+	zstdDecoders = sync.Pool{New: func() interface{} {
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		return decoder
+	}}
+)
+
+func compress(algorithm Compression, data []byte) ([]byte, error) {
+	switch algorithm {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		zstdEncoderOnce.Do(func() {
+			zstdEncoder, zstdEncoderErr = zstd.NewWriter(nil)
+		})
+		if zstdEncoderErr != nil {
+			return nil, zstdEncoderErr
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+	return nil, fmt.Errorf("unknown compression %s", algorithm)
+}
+
+func decompress(algorithm Compression, data []byte) ([]byte, error) {
+	var r io.Reader
+	switch algorithm {
+	case CompressionGzip:
+		gr, _ := gzipReaders.Get().(*gzip.Reader)
+		var err error
+		if gr == nil {
+			gr, err = gzip.NewReader(bytes.NewReader(data))
+		} else {
+			err = gr.Reset(bytes.NewReader(data))
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReaders.Put(gr)
+		r = gr
+	case CompressionZstd:
+		decoder, ok := zstdDecoders.Get().(*zstd.Decoder)
+		if !ok {
+			return nil, fmt.Errorf("failed to create zstd decoder")
+		}
+		if err := decoder.Reset(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		defer zstdDecoders.Put(decoder)
+		r = decoder
+	default:
+		return nil, fmt.Errorf("unknown compression %s", algorithm)
+	}
+
+	// Reading one byte past the limit tells a payload of exactly MaxDecompressedSize from a larger one
+	decompressed, err := io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decompressed)) > MaxDecompressedSize {
+		return nil, ErrorDecompressedTooLarge
+	}
+	return decompressed, nil
+}
+
+// compressEnvelope compresses an encoded envelope when it reaches the options minimum size and does not exceed
+// MaxDecompressedSize, keeping the codec marker. It returns the compressed size over the original size, or 0 when the envelope was not compressed
+func compressEnvelope(opts *CompressionOptions, kind string, data []byte) ([]byte, float64) {
+	if opts == nil || len(data) < opts.minSize() {
+		return data, 0
+	}
+
+	params, body, marked := decodeFrame(data)
+	if !marked {
+		params = make(frameParams)
+	}
+	if params["compression"] != "" || int64(len(body)) > MaxDecompressedSize {
+		return data, 0
+	}
+	compressed, err := compress(opts.algorithm(), body)
+	if err != nil || len(compressed) >= len(body) {
+		return data, 0
+	}
+
+	params["compression"] = string(opts.algorithm())
+	ratio := float64(len(compressed)) / float64(len(body))
+	compressionRatio.WithLabelValues(string(opts.algorithm()), kind).Observe(ratio)
+	return encodeFrame(params, compressed), ratio
+}
+
+// decompressEnvelope returns the parameters and the decompressed body of a marked envelope
+func decompressEnvelope(params frameParams, body []byte) ([]byte, error) {
+	algorithm := params["compression"]
+	if algorithm == "" {
+		return body, nil
+	}
+	return decompress(Compression(algorithm), body)
+}
@@ -0,0 +1,171 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+)
+
+// callContext is the context.Context handed to CallHandler through Call.Context. Unlike the standard library
+// contexts its deadline can be extended, which is what happens when the handler calls Call.Timeout
+type callContext struct {
+	context.Context
+	m        sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+	done     chan struct{}
+	err      error
+}
+
+func newCallContext(parent context.Context, deadline time.Time) (*callContext, context.CancelFunc) {
+	c := &callContext{
+		Context:  parent,
+		deadline: deadline,
+		done:     make(chan struct{}),
+	}
+
+	if !deadline.IsZero() {
+		// The timer may fire before it is assigned when the deadline is close
+		c.m.Lock()
+		c.timer = time.AfterFunc(time.Until(deadline), func() { c.cancel(context.DeadlineExceeded) })
+		c.m.Unlock()
+	}
+
+	if parent.Done() != nil {
+		go func() {
+			select {
+			case <-parent.Done():
+				c.cancel(parent.Err())
+			case <-c.done:
+			}
+		}()
+	}
+	return c, func() { c.cancel(context.Canceled) }
+}
+
+type callContextKey struct{}
+
+// callContextFrom returns the callContext ctx derives from, nil when there is none
+func callContextFrom(ctx context.Context) *callContext {
+	if ctx == nil {
+		return nil
+	}
+	c, _ := ctx.Value(callContextKey{}).(*callContext)
+	return c
+}
+
+func (c *callContext) Value(key interface{}) interface{} {
+	if key == (callContextKey{}) {
+		return c
+	}
+	return c.Context.Value(key)
+}
+
+func (c *callContext) Deadline() (time.Time, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.deadline.IsZero() {
+		return c.Context.Deadline()
+	}
+	return c.deadline, true
+}
+
+func (c *callContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *callContext) Err() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.err
+}
+
+// extend moves the deadline to timeout from now, unless the context is already done
+func (c *callContext) extend(timeout time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.err != nil {
+		return
+	}
+
+	c.deadline = time.Now().Add(timeout)
+	if c.timer == nil {
+		c.timer = time.AfterFunc(timeout, func() { c.cancel(context.DeadlineExceeded) })
+		return
+	}
+	c.timer.Reset(timeout)
+}
+
+func (c *callContext) cancel(err error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	close(c.done)
+}
+
+// detachedContext carries the values of a Call context but is only cancelled with the context the Call was bound to,
+// like the APIService one, so it outlives the handler
+type detachedContext struct {
+	context.Context
+	parent context.Context
+}
+
+func (c *detachedContext) Deadline() (time.Time, bool) {
+	return c.parent.Deadline()
+}
+
+func (c *detachedContext) Done() <-chan struct{} {
+	return c.parent.Done()
+}
+
+func (c *detachedContext) Err() error {
+	return c.parent.Err()
+}
+
+// contextProvider binds a Provider to a Call context so requests made from inside a CallHandler inherit its deadline.
+// Requests made after the handler returns, from goroutines it started, still run until that deadline
+type contextProvider struct {
+	Provider
+	ctx context.Context
+}
+
+// context returns the context of a request made through the Provider. It carries the Call context values and its
+// current deadline, and is cancelled with the context the Call was bound to instead of when the handler returns
+func (p *contextProvider) context() (context.Context, context.CancelFunc) {
+	call := callContextFrom(p.ctx)
+	if call == nil {
+		return p.ctx, func() {}
+	}
+	ctx := &detachedContext{Context: p.ctx, parent: call.Context}
+	if deadline, ok := call.Deadline(); ok {
+		return context.WithDeadline(ctx, deadline)
+	}
+	return ctx, func() {}
+}
+
+func (p *contextProvider) Get(pattern rids.Pattern, rs interface{}, token ...[]byte) Error {
+	ctx, cancel := p.context()
+	defer cancel()
+	return p.Provider.GetCtx(ctx, pattern, rs, token...)
+}
+
+func (p *contextProvider) Request(pattern rids.Pattern, payload interface{}, rs interface{}, token ...[]byte) Error {
+	ctx, cancel := p.context()
+	defer cancel()
+	return p.Provider.RequestCtx(ctx, pattern, payload, rs, token...)
+}
+
+func (p *contextProvider) Publish(pattern rids.Pattern, payload interface{}, token ...[]byte) Error {
+	ctx, cancel := p.context()
+	defer cancel()
+	return p.Provider.PublishCtx(ctx, pattern, payload, token...)
+}
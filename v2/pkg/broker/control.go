@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// HeaderAcceptControl is sent on calls to inform the handler the caller understands control frames of the given
+// ControlProtocol. Handlers reply the legacy timeout:<ns> string to callers that do not send it
+const HeaderAcceptControl = "acceptControl"
+
+// ControlProtocol is the version of the control frames
+const ControlProtocol = 2
+
+// ControlKind identifies what a control frame informs the caller while the handler runs
+type ControlKind string
+
+const (
+	// ControlExtend extends the time the caller waits for the reply to Control.Timeout
+	ControlExtend ControlKind = "extend"
+	// ControlProgress carries Control.Data informing the progress of the handler and restarts the caller wait
+	ControlProgress ControlKind = "progress"
+)
+
+// Control is a message the handler sends the caller before its reply
+type Control struct {
+	Kind    ControlKind
+	Timeout time.Duration
+	Data    RawData
+}
+
+// legacyTimeout matches the timeout extension replied to callers that do not send HeaderAcceptControl
+var legacyTimeout = regexp.MustCompile(`^timeout:(\d{1,19})$`)
+
+// encodeControl encodes ctl as a marked envelope with the control parameter
+func encodeControl(ctl Control) []byte {
+	params := frameParams{"control": string(ctl.Kind), "v": strconv.Itoa(ControlProtocol)}
+	if ctl.Kind == ControlExtend {
+		params["timeout"] = strconv.FormatInt(int64(ctl.Timeout), 10)
+	}
+	return encodeFrame(params, ctl.Data)
+}
+
+// DecodeControl decodes the control frames, and the legacy timeout extension, received by Provider implementations
+// while waiting for a reply. It returns nil for replies
+func DecodeControl(data []byte) (*Control, Error) {
+	if match := legacyTimeout.FindSubmatch(data); match != nil {
+		timeout, err := strconv.ParseInt(string(match[1]), 10, 64)
+		if err != nil {
+			return nil, NewInvalidParamsError(fmt.Sprintf("invalid timeout %s", match[1]))
+		}
+		return &Control{Kind: ControlExtend, Timeout: time.Duration(timeout)}, nil
+	}
+
+	params, body, marked := decodeFrame(data)
+	if !marked || params["control"] == "" {
+		return nil, nil
+	}
+	ctl := &Control{Kind: ControlKind(params["control"]), Data: body}
+	switch ctl.Kind {
+	case ControlExtend:
+		timeout, err := strconv.ParseInt(params["timeout"], 10, 64)
+		if err != nil {
+			return nil, NewInvalidParamsError(fmt.Sprintf("invalid timeout %s", params["timeout"]))
+		}
+		ctl.Timeout = time.Duration(timeout)
+	case ControlProgress:
+	default:
+		return nil, NewInvalidParamsError(fmt.Sprintf("unknown control %s", ctl.Kind))
+	}
+	return ctl, nil
+}
+
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx calling handler with the Data of each ControlProgress received by the requests
+// made with it
+func WithProgress(ctx context.Context, handler func(data RawData)) context.Context {
+	return context.WithValue(ctx, progressKey{}, handler)
+}
+
+// NotifyProgress calls the progress handler set on ctx with WithProgress, if any. Provider implementations call it
+// for each ControlProgress received
+func NotifyProgress(ctx context.Context, data RawData) {
+	if handler, ok := ctx.Value(progressKey{}).(func(data RawData)); ok {
+		handler(data)
+	}
+}
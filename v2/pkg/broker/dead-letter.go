@@ -0,0 +1,252 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+)
+
+// maxDeadLetters is how many dead-lettered events a Provider holds, the oldest ones are discarded first
+const maxDeadLetters = 1000
+
+// ackPrefix marks the reply endpoints given to monitor handlers, NewCallFromJSON keeps them out of Call.Reply and
+// their replies never leave the Provider
+const ackPrefix = "_ACK."
+
+// DeadLetter is an event a monitor failed to handle on every attempt of its Redelivery policy. Monitors acknowledge
+// an event by returning and reject it by calling Call.Error or panicking. Rejected events are delivered again after
+// the policy backoff, by JetStream on durable events. Once the attempts end the DeadLetter is held by the Provider and
+// published on rids.DeadLetter of the event Pattern. Dead letters are process-local: the Provider keeps the last 1000
+// of its own monitors in memory and they are lost when it stops. Provider.DeadLetters, DeadLetter and
+// ReplayDeadLetter only reach the dead letters held by that Provider, not the ones of other instances, so monitor
+// rids.DeadLetter to collect and keep them
+type DeadLetter struct {
+	ID       string    `json:"id"`
+	Group    string    `json:"group"`
+	Endpoint string    `json:"endpoint"`
+	Payload  RawData   `json:"payload"`
+	Attempts int       `json:"attempts"`
+	Code     int       `json:"code"`
+	Message  string    `json:"message,omitempty"`
+	FailedAt time.Time `json:"failedAt"`
+	monitor  string
+}
+
+// ackResult records the error replied by a monitor handler
+type ackResult struct {
+	m   sync.Mutex
+	err Error
+}
+
+func (a *ackResult) set(payload []byte) {
+	// Timeout extensions and progress do not acknowledge the event
+	if ctl, _ := DecodeControl(payload); ctl != nil {
+		return
+	}
+	reply, _, _ := decodeReply(payload)
+	a.m.Lock()
+	defer a.m.Unlock()
+	switch {
+	case reply == nil:
+		a.err = NewError("invalid reply", http.StatusInternalServerError, payload)
+	case math.Abs(float64(reply.Code()-http.StatusOK)) >= 100:
+		a.err = reply
+	default:
+		a.err = nil
+	}
+}
+
+func (a *ackResult) get() Error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.err
+}
+
+// ackMonitor delivers the events of a monitor following its Redelivery policy, policy is nil on durable monitors
+// delivering each event once
+type ackMonitor struct {
+	base    *specificProviderBase
+	group   string
+	sub     Subscription
+	handler ServiceHandler
+	policy  *rids.RetryPolicy
+}
+
+func (m *ackMonitor) key() string {
+	return m.group + " " + m.sub.Resource.EndpointNameSpecific()
+}
+
+// handle is the ServiceHandler subscribed on the SpecificProvider
+func (m *ackMonitor) handle(sub Subscription, payload []byte, _ string) {
+	m.try(sub, payload, 1)
+}
+
+// try runs the attempt-th delivery of an event. Redeliveries are scheduled after the policy backoff instead of holding
+// the worker, and count as in flight so Drain waits for them
+func (m *ackMonitor) try(sub Subscription, payload []byte, attempt int) {
+	rErr := m.attempt(sub, payload)
+	if rErr == nil || !m.reject(sub, payload, attempt, rErr) {
+		return
+	}
+	sub.track(1)
+	time.AfterFunc(m.policy.Backoff(attempt), func() {
+		defer sub.track(-1)
+		m.try(sub, payload, attempt+1)
+	})
+}
+
+// handleDurable is the DurableHandler subscribed on DurableSubscriber implementations, which deliver the rejected
+// events again after the policy backoff. Events still rejected once the attempts end are terminated
+func (m *ackMonitor) handleDurable(sub Subscription, payload []byte, attempt int) (AckKind, time.Duration) {
+	rErr := m.attempt(sub, payload)
+	switch {
+	case rErr == nil:
+		return AckDone, 0
+	case m.policy != nil && m.reject(sub, payload, attempt, rErr):
+		return AckRetry, m.policy.Backoff(attempt)
+	}
+	return AckTerm, 0
+}
+
+// deliver runs the handler until it acknowledges the event or the attempts end. It replays dead letters, waiting the
+// backoffs on the caller goroutine
+func (m *ackMonitor) deliver(sub Subscription, payload []byte) Error {
+	for attempt := 1; ; attempt++ {
+		rErr := m.attempt(sub, payload)
+		if rErr == nil || !m.reject(sub, payload, attempt, rErr) {
+			return rErr
+		}
+		time.Sleep(m.policy.Backoff(attempt))
+	}
+}
+
+// reject reports if an event rejected on the attempt-th delivery must be delivered again, dead-lettering it once the
+// attempts end
+func (m *ackMonitor) reject(sub Subscription, payload []byte, attempt int, rErr Error) bool {
+	retried := len(m.policy.Codes) == 0 || m.policy.Retries(rErr.Code())
+	if attempt >= m.policy.MaxAttempts || !retried {
+		m.base.deadLetter(m, sub.Resource, payload, attempt, rErr)
+		return false
+	}
+	monitorRedeliveries.WithLabelValues(sub.Resource.EndpointName(), m.group).Inc()
+	return true
+}
+
+// attempt runs the handler once with a reply endpoint recording its result
+func (m *ackMonitor) attempt(sub Subscription, payload []byte) (rErr Error) {
+	ack := fmt.Sprintf("%s%d", ackPrefix, atomic.AddUint64(&m.base.ackSeq, 1))
+	result := &ackResult{}
+	m.base.acks.Store(ack, result)
+	defer m.base.acks.Delete(ack)
+
+	defer func() {
+		if r := recover(); r != nil {
+			rErr = InternalError(fmt.Errorf("monitor: panic on handler %s: %v", sub.Resource.EndpointName(), r))
+		}
+	}()
+	m.handler(sub, payload, ack)
+	return result.get()
+}
+
+// redeliveryPolicy returns the policy applied to the monitor of sub, nil when events are delivered once
+func (s *specificProviderBase) redeliveryPolicy(sub Subscription) *rids.RetryPolicy {
+	policy := sub.Redelivery
+	if policy == nil {
+		policy = s.opts.Redelivery
+	}
+	if policy == nil || policy.MaxAttempts < 1 {
+		return nil
+	}
+	return policy
+}
+
+// deadLetter holds the event rejected on every attempt and publishes it on its dead-letter Pattern
+func (s *specificProviderBase) deadLetter(m *ackMonitor, p rids.Pattern, payload []byte, attempts int, rErr Error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Printf("monitor: failed to dead-letter event on %s: %v", p.EndpointNameSpecific(), err)
+		return
+	}
+	// The subscribed Pattern is generic, the event carries the specific one
+	if c, err := NewCallFromJSON(payload, p, ""); err == nil && c.Endpoint() != nil {
+		p = c.Endpoint()
+	}
+	letter := DeadLetter{
+		ID:       id.String(),
+		Group:    m.group,
+		Endpoint: p.EndpointNameSpecific(),
+		Payload:  payload,
+		Attempts: attempts,
+		Code:     rErr.Code(),
+		Message:  rErr.Error(),
+		FailedAt: time.Now(),
+		monitor:  m.key(),
+	}
+	deadLetters.WithLabelValues(p.EndpointName(), m.group).Inc()
+
+	s.deadM.Lock()
+	s.dead = append(s.dead, letter)
+	if len(s.dead) > maxDeadLetters {
+		s.dead = s.dead[len(s.dead)-maxDeadLetters:]
+	}
+	s.deadM.Unlock()
+
+	data, err := json.Marshal(letter)
+	if err != nil {
+		log.Printf("monitor: failed to encode dead letter %s: %v", letter.ID, err)
+		return
+	}
+	if rErr = s.Publish(rids.DeadLetter(p), json.RawMessage(data)); rErr != nil {
+		log.Printf("monitor: failed to publish dead letter %s on %s: %v", letter.ID, p.EndpointNameSpecific(), rErr)
+	}
+}
+
+func (s *specificProviderBase) DeadLetters() []DeadLetter {
+	s.deadM.Lock()
+	defer s.deadM.Unlock()
+	return append([]DeadLetter(nil), s.dead...)
+}
+
+func (s *specificProviderBase) DeadLetter(id string) (DeadLetter, Error) {
+	s.deadM.Lock()
+	defer s.deadM.Unlock()
+	for _, letter := range s.dead {
+		if letter.ID == id {
+			return letter, nil
+		}
+	}
+	return DeadLetter{}, ErrorNotFound
+}
+
+func (s *specificProviderBase) ReplayDeadLetter(id string) Error {
+	letter, rErr := s.DeadLetter(id)
+	if rErr != nil {
+		return rErr
+	}
+	value, ok := s.monitors.Load(letter.monitor)
+	if !ok {
+		return NewServiceUnavailableError(letter.Endpoint)
+	}
+	s.removeDeadLetter(id)
+	m := value.(*ackMonitor)
+	return m.deliver(m.sub, letter.Payload)
+}
+
+func (s *specificProviderBase) removeDeadLetter(id string) {
+	s.deadM.Lock()
+	defer s.deadM.Unlock()
+	for i, letter := range s.dead {
+		if letter.ID == id {
+			s.dead = append(s.dead[:i], s.dead[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Package dedup discards the events a monitor already processed, using the idempotency key sent on the
+// broker.HeaderIdempotencyKey header
+package dedup
+
+import (
+	"github.com/spike-events/spike-broker/v2/pkg/broker"
+)
+
+// Store holds a bounded set of claimed idempotency keys
+type Store interface {
+	// Claim records key as processed, reporting false when it was already claimed. Checking and recording the key
+	// must be atomic, so a single caller claims it. The oldest keys are discarded once the store is full
+	Claim(key string) (bool, error)
+
+	// Release forgets a claimed key, so the event is processed again when redelivered
+	Release(key string) error
+}
+
+// Middleware returns a CallMiddleware answering OK to the events whose idempotency key is on store without calling
+// the handler. Keys are claimed before the handler runs, so concurrent deliveries of an event are handled once, and
+// released when it fails or panics, so events rejected by it are processed again when redelivered. Calls without a
+// key are always handled. Keys are scoped by the endpoint and the monitoring group of the event, so the same key sent on
+// different events or handled by different groups is claimed by each of them
+func Middleware(store Store) broker.CallMiddleware {
+	return func(next broker.CallHandler) broker.CallHandler {
+		return func(c broker.Call) {
+			key := c.Headers()[broker.HeaderIdempotencyKey]
+			if key == "" {
+				next(c)
+				return
+			}
+
+			key = scope(c) + key
+			claimed, err := store.Claim(key)
+			if err != nil {
+				c.Error(broker.InternalError(err))
+				return
+			}
+			if !claimed {
+				c.OK()
+				return
+			}
+
+			handled := false
+			defer func() {
+				if handled && c.GetError() == nil {
+					return
+				}
+				if err := store.Release(key); err != nil && handled {
+					c.Error(broker.InternalError(err))
+				}
+			}()
+			next(c)
+			handled = true
+		}
+	}
+}
+
+// scope returns the prefix of the keys claimed for c
+func scope(c broker.Call) string {
+	return c.Endpoint().EndpointName() + " " + broker.MonitoringGroupFromContext(c.Context()) + " "
+}
@@ -0,0 +1,58 @@
+package dedup
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProcessedEvent is the record of an idempotency key on the gorm Store. ID follows the order keys were claimed in
+type ProcessedEvent struct {
+	ID          uint64 `gorm:"primaryKey;autoIncrement"`
+	Key         string `gorm:"uniqueIndex"`
+	ProcessedAt time.Time
+}
+
+type gormStore struct {
+	db   *gorm.DB
+	size int
+}
+
+// NewGormStore returns a Store keeping the last size keys on db, so instances sharing it discard the events
+// processed by each other. The ProcessedEvent table is migrated when needed
+func NewGormStore(db *gorm.DB, size int) (Store, error) {
+	if err := db.AutoMigrate(&ProcessedEvent{}); err != nil {
+		return nil, err
+	}
+	return &gormStore{
+		db:   db,
+		size: size,
+	}, nil
+}
+
+func (s *gormStore) Claim(key string) (bool, error) {
+	claimed := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// The unique key makes the insert fail to claim keys already recorded
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&ProcessedEvent{Key: key, ProcessedAt: time.Now()})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		claimed = true
+
+		// Keys claimed before the last size ones are discarded
+		var oldest []ProcessedEvent
+		err := tx.Order("id desc").Offset(s.size).Limit(1).Find(&oldest).Error
+		if err != nil || len(oldest) == 0 {
+			return err
+		}
+		return tx.Where("id <= ?", oldest[0].ID).Delete(&ProcessedEvent{}).Error
+	})
+	return claimed && err == nil, err
+}
+
+func (s *gormStore) Release(key string) error {
+	return s.db.Where(&ProcessedEvent{Key: key}).Delete(&ProcessedEvent{}).Error
+}
@@ -0,0 +1,49 @@
+package dedup
+
+import "sync"
+
+type memoryStore struct {
+	m     sync.Mutex
+	size  int
+	keys  map[string]struct{}
+	order []string
+}
+
+// NewMemoryStore returns a Store keeping the last size keys in memory
+func NewMemoryStore(size int) Store {
+	return &memoryStore{
+		size: size,
+		keys: make(map[string]struct{}, size),
+	}
+}
+
+func (s *memoryStore) Claim(key string) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.keys[key]; ok {
+		return false, nil
+	}
+	s.keys[key] = struct{}{}
+	s.order = append(s.order, key)
+	for len(s.order) > s.size {
+		delete(s.keys, s.order[0])
+		s.order = s.order[1:]
+	}
+	return true, nil
+}
+
+func (s *memoryStore) Release(key string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.keys[key]; !ok {
+		return nil
+	}
+	delete(s.keys, key)
+	for i, claimed := range s.order {
+		if claimed == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// Tracker counts the messages dispatched to a set of handlers until they are handled, and the requests and publishes
+// made while they run. Subscriptions with a Tracker count their handlers on it and calls made with a context carrying
+// it, see WithTracker, count on it as well, so the owner of the subscriptions can wait for them before stopping
+type Tracker struct {
+	m     sync.Mutex
+	count int
+	idle  chan struct{}
+}
+
+// NewTracker returns a Tracker with nothing in flight
+func NewTracker() *Tracker {
+	return &Tracker{}
+}
+
+func (t *Tracker) add(delta int) {
+	if t == nil {
+		return
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.count += delta
+	if t.count == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// Len returns how many messages and calls are in flight
+func (t *Tracker) Len() int {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.count
+}
+
+// Wait waits until nothing is in flight or ctx is done, returning how many messages and calls were still in flight
+func (t *Tracker) Wait(ctx context.Context) int {
+	select {
+	case <-t.idleChan():
+	case <-ctx.Done():
+	}
+	return t.Len()
+}
+
+// idleChan returns a channel closed once nothing is in flight
+func (t *Tracker) idleChan() <-chan struct{} {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.count == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	return t.idle
+}
+
+type trackerKey struct{}
+
+// WithTracker returns a copy of ctx counting the requests and publishes made with it on t until they return
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, t)
+}
+
+// trackerFromContext returns the Tracker carried by ctx, nil when there is none
+func trackerFromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(trackerKey{}).(*Tracker)
+	return t
+}
+
+// track counts a message on the Provider and on the Subscription Tracker
+func (s Subscription) track(delta int) {
+	s.inFlight.add(delta)
+	s.Tracker.add(delta)
+}
+
+// track counts an outgoing call on the Provider and on the Tracker of ctx until the returned function is called
+func (s *specificProviderBase) track(ctx context.Context) func() {
+	t := trackerFromContext(ctx)
+	s.inFlight.add(1)
+	t.add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.inFlight.add(-1)
+			t.add(-1)
+		})
+	}
+}
+
+// register keeps unsubscribe until it is called or the Provider is drained, returning the function that must be
+// handed to the caller in its place
+func (s *specificProviderBase) register(unsubscribe func()) func() {
+	s.subsM.Lock()
+	defer s.subsM.Unlock()
+	if s.subs == nil {
+		s.subs = make(map[uint64]func())
+	}
+	s.subsSeq++
+	id := s.subsSeq
+
+	var once sync.Once
+	registered := func() {
+		once.Do(func() {
+			s.subsM.Lock()
+			delete(s.subs, id)
+			s.subsM.Unlock()
+			unsubscribe()
+		})
+	}
+	s.subs[id] = registered
+	return registered
+}
+
+// Drain unsubscribes every handler and monitor, waits for the messages already received to be handled and for the
+// pending requests and publishes, and closes the Provider. It returns how many of them were still in flight when ctx
+// was done
+func (s *specificProviderBase) Drain(ctx context.Context) int {
+	s.subsM.Lock()
+	unsubscribes := make([]func(), 0, len(s.subs))
+	for _, unsubscribe := range s.subs {
+		unsubscribes = append(unsubscribes, unsubscribe)
+	}
+	s.subsM.Unlock()
+
+	unsubscribed := make(chan struct{})
+	go func() {
+		defer close(unsubscribed)
+		for _, unsubscribe := range unsubscribes {
+			unsubscribe()
+		}
+	}()
+
+	select {
+	case <-unsubscribed:
+	case <-ctx.Done():
+	}
+	abandoned := s.inFlight.Wait(ctx)
+	s.impl.Close()
+	return abandoned
+}
@@ -1,6 +1,7 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -84,6 +85,26 @@ func (e *empty) File(f *dataurl.DataURL) {}
 
 func (e *empty) OK(result ...interface{}) {}
 
+func (e *empty) Stream() Stream {
+	return NewBufferedStream(e)
+}
+
 func (e *empty) Timeout(timeout time.Duration) {}
 
+func (e *empty) Progress(data interface{}) {}
+
 func (e *empty) NotFound() {}
+
+func (e *empty) Headers() map[string]string {
+	return nil
+}
+
+func (e *empty) SetHeaders(_ map[string]string) {}
+
+func (e *empty) Context() context.Context {
+	return context.Background()
+}
+
+func (e *empty) SetContext(_ context.Context) context.CancelFunc {
+	return func() {}
+}
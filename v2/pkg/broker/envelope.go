@@ -0,0 +1,194 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+)
+
+// HeaderAcceptCodec is sent on JSON calls to inform the handler the caller understands replies on another codec
+const HeaderAcceptCodec = "acceptCodec"
+
+// framePrefix marks envelopes that are not plain JSON. The marker is followed by ";" separated key=value parameters,
+// like codec=msgpack, and a line break before the encoded envelope. Unmarked envelopes are JSON, so Spike versions
+// that do not know the marker keep working as long as they are only sent JSON
+const framePrefix = "\x00spike"
+
+type frameParams map[string]string
+
+// callEnvelope is the Call representation encoded by non JSON codecs
+type callEnvelope struct {
+	Data          []byte            `json:"data,omitempty"`
+	Reply         string            `json:"reply,omitempty"`
+	Endpoint      []byte            `json:"endpoint"`
+	Token         []byte            `json:"token,omitempty"`
+	TimeoutBudget time.Duration     `json:"timeoutBudget,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+}
+
+// replyEnvelope is the reply representation encoded by non JSON codecs
+type replyEnvelope struct {
+	Code    int    `json:"code"`
+	Data    []byte `json:"data,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func encodeFrame(params frameParams, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(framePrefix)
+	for key, value := range params {
+		buf.WriteString(";" + key + "=" + value)
+	}
+	buf.WriteByte('\n')
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// decodeFrame splits a marked envelope into its parameters and body, reporting false on unmarked envelopes
+func decodeFrame(data []byte) (frameParams, []byte, bool) {
+	if !bytes.HasPrefix(data, []byte(framePrefix)) {
+		return nil, data, false
+	}
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return nil, data, false
+	}
+
+	params := make(frameParams)
+	for _, param := range strings.Split(string(data[len(framePrefix):end]), ";") {
+		if key, value, found := strings.Cut(param, "="); found {
+			params[key] = value
+		}
+	}
+	return params, data[end+1:], true
+}
+
+// frameCodec returns the codec named on params, JSONCodec when none is named
+func frameCodec(params frameParams) (Codec, error) {
+	if params["codec"] == "" {
+		return JSONCodec, nil
+	}
+	codec := CodecByName(params["codec"])
+	if codec == nil {
+		return nil, fmt.Errorf("unknown codec %s", params["codec"])
+	}
+	return codec, nil
+}
+
+// encodeCall encodes c with codec, keeping the JSON envelope for the JSON codec and for Calls of other providers
+func encodeCall(c Call, codec Codec) ([]byte, error) {
+	callMsg, ok := c.(*call)
+	if !ok || isLegacyCodec(codec) {
+		return c.ToJSON(), nil
+	}
+
+	endpoint, err := json.Marshal(callMsg.EndpointPattern)
+	if err != nil {
+		return nil, err
+	}
+	body, err := codec.Marshal(&callEnvelope{
+		Data:          callMsg.Data,
+		Reply:         callMsg.ReplyStr,
+		Endpoint:      endpoint,
+		Token:         callMsg.Token,
+		TimeoutBudget: callMsg.TimeoutBudget,
+		Headers:       callMsg.HeadersMap,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return encodeFrame(frameParams{"codec": codec.Name()}, body), nil
+}
+
+// decodeCall decodes a Call envelope encoded with codec
+func decodeCall(codec Codec, body []byte) (*call, error) {
+	var envelope callEnvelope
+	if err := codec.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+	pattern, err := rids.UnmarshalPattern(envelope.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &call{
+		callBase: callBase{
+			Data:            envelope.Data,
+			ReplyStr:        envelope.Reply,
+			EndpointPattern: pattern,
+			Token:           envelope.Token,
+			TimeoutBudget:   envelope.TimeoutBudget,
+			HeadersMap:      envelope.Headers,
+			codec:           codec,
+		},
+		APIVersion: 2,
+	}
+	if c.TimeoutBudget > 0 {
+		c.deadline = time.Now().Add(c.TimeoutBudget)
+	}
+	return c, nil
+}
+
+// encodeReply encodes a reply with codec, using the JSON envelopes for the JSON codec
+func encodeReply(codec Codec, code int, data []byte, message string) []byte {
+	if isLegacyCodec(codec) {
+		reply := errorMessage{
+			Message: Message{
+				CodeInt:   code,
+				DataIface: data,
+			},
+			MessageStr: message,
+		}
+		encoded, err := json.Marshal(&reply)
+		if err != nil {
+			panic(err)
+		}
+		return encoded
+	}
+
+	body, err := codec.Marshal(&replyEnvelope{
+		Code:    code,
+		Data:    data,
+		Message: message,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return encodeFrame(frameParams{"codec": codec.Name()}, body)
+}
+
+// decodeReply decodes a reply envelope returning it with the codec it was encoded with and whether it was marked
+func decodeReply(data []byte) (Error, Codec, bool) {
+	params, body, marked := decodeFrame(data)
+	if !marked {
+		return NewMessageFromJSON(data), JSONCodec, false
+	}
+
+	body, err := decompressEnvelope(params, body)
+	if err != nil {
+		return nil, nil, true
+	}
+	codec, err := frameCodec(params)
+	if err != nil {
+		return nil, nil, true
+	}
+	if isLegacyCodec(codec) {
+		return NewMessageFromJSON(body), codec, true
+	}
+
+	var envelope replyEnvelope
+	if err = codec.Unmarshal(body, &envelope); err != nil {
+		return nil, nil, true
+	}
+	return &errorMessage{
+		Message: Message{
+			CodeInt:   envelope.Code,
+			DataIface: envelope.Data,
+		},
+		MessageStr: envelope.Message,
+	}, codec, true
+}
@@ -1,6 +1,7 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -44,6 +45,9 @@ func (e *errorMessage) ToJSON() []byte {
 	return data
 }
 
+// StatusClientClosedRequest is used when the caller gives up on a request before receiving the response
+const StatusClientClosedRequest = 499
+
 // information already exists
 var (
 	ErrorInformationAlreadyExists Error = &errorMessage{Message{http.StatusAlreadyReported, nil}, "information already exists"}
@@ -55,6 +59,7 @@ var (
 	ErrorAccessDenied             Error = &errorMessage{Message{http.StatusForbidden, nil}, "access denied"}
 	ErrorTimeout                  Error = &errorMessage{Message{http.StatusRequestTimeout, nil}, "timeout"}
 	ErrorServiceUnavailable       Error = &errorMessage{Message{http.StatusServiceUnavailable, nil}, "service unavailable"}
+	ErrorRequestCanceled          Error = &errorMessage{Message{StatusClientClosedRequest, nil}, "request canceled"}
 )
 
 func InternalError(err error) Error {
@@ -70,6 +75,19 @@ func InternalError(err error) Error {
 	}
 }
 
+// ErrorFromContext returns the Error matching ctx state: ErrorTimeout when its deadline was exceeded,
+// ErrorRequestCanceled when it was cancelled and nil while it is still active
+func ErrorFromContext(ctx context.Context) Error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return ErrorTimeout
+	default:
+		return ErrorRequestCanceled
+	}
+}
+
 func NewInvalidParamsError(msg string) Error {
 	return &errorMessage{
 		MessageStr: msg,
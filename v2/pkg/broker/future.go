@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+)
+
+// Future is the pending result of RequestAsync
+type Future interface {
+	// Wait blocks until the response is received, returning its error. The response is decoded into the rs given to
+	// RequestAsync and must not be read before Wait returns
+	Wait() Error
+
+	// Done is closed once the response is received
+	Done() <-chan struct{}
+
+	// Cancel stops waiting for the response, Wait then returns ErrorRequestCanceled
+	Cancel()
+}
+
+type future struct {
+	done   chan struct{}
+	err    Error
+	cancel context.CancelFunc
+}
+
+// newFuture runs request in background with a cancellable ctx
+func newFuture(ctx context.Context, request func(ctx context.Context) Error) Future {
+	ctx, cancel := context.WithCancel(ctx)
+	f := &future{
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	go func() {
+		defer close(f.done)
+		defer cancel()
+		f.err = request(ctx)
+	}()
+	return f
+}
+
+func (f *future) Wait() Error {
+	<-f.done
+	return f.err
+}
+
+func (f *future) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *future) Cancel() {
+	f.cancel()
+}
+
+func (s *specificProviderBase) RequestAsync(ctx context.Context, p rids.Pattern, payload interface{}, rs interface{},
+	token ...[]byte) Future {
+	// Counted from now on, as the request only starts once the future runs
+	done := s.track(ctx)
+	return newFuture(ctx, func(ctx context.Context) Error {
+		defer done()
+		return s.RequestCtx(ctx, p, payload, rs, token...)
+	})
+}
+
+// WaitAll waits for every future returning the first error in the given order. Once timeout elapses, when greater
+// than zero, or ctx is done, the futures still pending are cancelled and ErrorTimeout or ErrorRequestCanceled is
+// returned after they end, so their responses are not decoded after WaitAll returns
+func WaitAll(ctx context.Context, timeout time.Duration, futures ...Future) Error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	for i, f := range futures {
+		select {
+		case <-f.Done():
+		case <-ctx.Done():
+			for _, pending := range futures[i:] {
+				pending.Cancel()
+			}
+			for _, pending := range futures[i:] {
+				<-pending.Done()
+			}
+			return ErrorFromContext(ctx)
+		}
+	}
+
+	for _, f := range futures {
+		if err := f.Wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+	"github.com/spike-events/spike-broker/v2/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestAllOptions sets when RequestAll stops collecting replies
+type RequestAllOptions struct {
+	// Timeout is how long to wait for the replies, the Provider timeout when zero
+	Timeout time.Duration
+
+	// Expected returns as soon as this number of replies is collected when greater than zero
+	Expected int
+}
+
+// InstanceReply is the reply of one instance to RequestAll. Err is set when the instance answered with an error
+type InstanceReply struct {
+	Data RawData
+	Err  Error
+}
+
+// Decode unmarshals the reply data into v
+func (r InstanceReply) Decode(v interface{}) error {
+	return json.Unmarshal(r.Data, v)
+}
+
+// subscribeBroadcast subscribes sub on its broadcast subject without a queue group, so every instance receives the
+// calls. The handler receives the original Subscription
+func (s *specificProviderBase) subscribeBroadcast(sub Subscription, handler ServiceHandler) (func(), Error) {
+	broadcast := sub
+	broadcast.Resource = rids.Broadcast(sub.Resource)
+	return s.impl.SubscribeRaw(broadcast, "", func(_ Subscription, payload []byte, replyEndpoint string) {
+		handler(sub, payload, replyEndpoint)
+	})
+}
+
+func (s *specificProviderBase) RequestAll(ctx context.Context, p rids.Pattern, payload interface{},
+	opts RequestAllOptions, token ...[]byte) ([]InstanceReply, Error) {
+	var replies []InstanceReply
+	rErr := s.invoke(ctx, InvocationGather, p, payload, token, func(ctx context.Context, inv *Invocation) Error {
+		ctx, span := tracing.Start(ctx, "gather", inv.Pattern.EndpointName(), string(inv.Pattern.Method()),
+			trace.SpanKindClient)
+		defer span.End()
+
+		var rErr Error
+		replies, rErr = s.requestAll(ctx, inv.Pattern, inv.Payload, opts, inv.tokens()...)
+		if rErr != nil {
+			tracing.RecordError(span, rErr.Code(), rErr.Error())
+		}
+		return rErr
+	})
+	return replies, rErr
+}
+
+// requestAll collects the replies of every instance. Instances may run different versions, so the call is always
+// sent as JSON without compression
+func (s *specificProviderBase) requestAll(ctx context.Context, p rids.Pattern, payload interface{},
+	opts RequestAllOptions, token ...[]byte) ([]InstanceReply, Error) {
+	if err := ErrorFromContext(ctx); err != nil {
+		return nil, err
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = s.timeout()
+	}
+	gatherCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		gatherCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	data := s.newRequestCall(gatherCtx, p, payload, nil, token...).ToJSON()
+
+	replies := make([]InstanceReply, 0)
+	rErr := s.requestAllRaw(gatherCtx, rids.Broadcast(p).EndpointName(), data, func(msg []byte) bool {
+		reply := InstanceReply{}
+		bMsg, _, _ := decodeReply(msg)
+		switch {
+		case bMsg == nil:
+			reply.Err = NewError("invalid payload", http.StatusInternalServerError, msg)
+		case math.Abs(float64(bMsg.Code()-http.StatusOK)) >= 100:
+			reply.Err = bMsg
+		default:
+			reply.Data = bMsg.Data()
+		}
+		replies = append(replies, reply)
+		return opts.Expected <= 0 || len(replies) < opts.Expected
+	})
+
+	if rErr != nil {
+		// Running out of time is how the collection ends when the number of instances is not known
+		expired := gatherCtx.Err() != nil || rErr.Code() == ErrorTimeout.Code()
+		if len(replies) == 0 || !expired || ctx.Err() != nil {
+			return nil, rErr
+		}
+	}
+	return replies, nil
+}
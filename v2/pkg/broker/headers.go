@@ -0,0 +1,46 @@
+package broker
+
+import "context"
+
+// Well known Call headers filled by the HTTP and WebSocket servers and by the APIService
+const (
+	HeaderRequestID     = "requestId"
+	HeaderClientIP      = "clientIp"
+	HeaderUserAgent     = "userAgent"
+	HeaderLocale        = "locale"
+	HeaderSessionID     = "sessionId"
+	HeaderCallerService = "callerService"
+
+	// HeaderIdempotencyKey identifies an event among its retries, it is not forwarded to nested calls
+	HeaderIdempotencyKey = "idempotencyKey"
+)
+
+type headersKey struct{}
+
+// WithHeaders returns a copy of ctx carrying headers merged over the ones already on ctx. Requests made with the
+// returned context send them on the Call envelope
+func WithHeaders(ctx context.Context, headers map[string]string) context.Context {
+	return context.WithValue(ctx, headersKey{}, mergeHeaders(HeadersFromContext(ctx), headers))
+}
+
+// HeadersFromContext returns a copy of the headers carried by ctx
+func HeadersFromContext(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(headersKey{}).(map[string]string)
+	return mergeHeaders(headers, nil)
+}
+
+// mergeHeaders returns a new map with override entries replacing the base ones
+func mergeHeaders(base, override map[string]string) map[string]string {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]string, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		merged[key] = value
+	}
+	return merged
+}
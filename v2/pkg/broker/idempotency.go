@@ -0,0 +1,38 @@
+package broker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+)
+
+// WithIdempotencyKey returns a copy of ctx publishing events with key on the HeaderIdempotencyKey header. Events
+// published again with the same key are discarded by JetStream on durable Patterns and by the dedup monitors
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return WithHeaders(ctx, map[string]string{HeaderIdempotencyKey: key})
+}
+
+// IdempotencyKey derives the idempotency key of an event from its specific endpoint and JSON encoded payload,
+// failing when the payload cannot be encoded
+func IdempotencyKey(p rids.Pattern, payload interface{}) (string, error) {
+	hash := sha256.New()
+	hash.Write([]byte(p.EndpointNameSpecific()))
+	hash.Write([]byte{0})
+	switch data := payload.(type) {
+	case nil:
+	case []byte:
+		hash.Write(data)
+	case string:
+		hash.Write([]byte(data))
+	default:
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return "", err
+		}
+		hash.Write(encoded)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
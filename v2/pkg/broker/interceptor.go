@@ -0,0 +1,52 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+)
+
+// InvocationKind identifies the Provider operation being intercepted
+type InvocationKind string
+
+const (
+	InvocationRequest   InvocationKind = "request"
+	InvocationStream    InvocationKind = "stream"
+	InvocationGather    InvocationKind = "gather"
+	InvocationPublish   InvocationKind = "publish"
+	InvocationMonitor   InvocationKind = "monitor"
+	InvocationSubscribe InvocationKind = "subscribe"
+)
+
+// Invocation describes an outgoing Provider call. Interceptors may change Pattern, Payload and Token before calling
+// the next Invoker. Payload is always nil on Monitor and Subscribe, Token is always nil on Subscribe
+type Invocation struct {
+	Kind    InvocationKind
+	Pattern rids.Pattern
+	Payload interface{}
+	Token   []byte
+}
+
+// Invoker executes an Invocation
+type Invoker func(ctx context.Context, inv *Invocation) Error
+
+// Interceptor runs around an Invocation. It must call next to proceed, or return an Error to short-circuit the call
+type Interceptor func(ctx context.Context, inv *Invocation, next Invoker) Error
+
+func (inv *Invocation) tokens() [][]byte {
+	if inv.Token == nil {
+		return nil
+	}
+	return [][]byte{inv.Token}
+}
+
+// chainInterceptors returns an Invoker running interceptors in order before final
+func chainInterceptors(interceptors []Interceptor, final Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], final
+		final = func(ctx context.Context, inv *Invocation) Error {
+			return interceptor(ctx, inv, next)
+		}
+	}
+	return final
+}
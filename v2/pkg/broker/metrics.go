@@ -0,0 +1,49 @@
+package broker
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var requestRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "spike",
+	Subsystem: "broker",
+	Name:      "request_retries_total",
+	Help:      "Requests retried by the retry policy, by endpoint and error code of the failed attempt",
+}, []string{"endpoint", "code"})
+
+var compressionRatio = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "spike",
+	Subsystem: "broker",
+	Name:      "compression_ratio",
+	Help:      "Compressed size over original size of the compressed envelopes, by algorithm and kind",
+	Buckets:   prometheus.LinearBuckets(0.1, 0.1, 10),
+}, []string{"algorithm", "kind"})
+
+var handlersInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "spike",
+	Subsystem: "broker",
+	Name:      "handlers_in_flight",
+	Help:      "Subscription handlers running, by endpoint",
+}, []string{"endpoint"})
+
+var handlerOverflows = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "spike",
+	Subsystem: "broker",
+	Name:      "handler_overflows_total",
+	Help:      "Messages rejected or dropped because the Subscription queue was full, by endpoint and policy",
+}, []string{"endpoint", "policy"})
+
+var monitorRedeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "spike",
+	Subsystem: "broker",
+	Name:      "monitor_redeliveries_total",
+	Help:      "Events delivered again to a monitor after its handler failed, by endpoint and monitoring group",
+}, []string{"endpoint", "group"})
+
+var deadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "spike",
+	Subsystem: "broker",
+	Name:      "dead_letters_total",
+	Help:      "Events dead-lettered after every delivery to a monitor failed, by endpoint and monitoring group",
+}, []string{"endpoint", "group"})
@@ -1,16 +1,71 @@
 package broker
 
 import (
+	"context"
+
 	"github.com/spike-events/spike-broker/v2/pkg/rids"
 )
 
 type ProviderType string
 
-// Subscription has the Resource, the Call handler and the optional Access validators
+// Subscription has the Resource, the Call handler, the optional Access validators and the optional middlewares run
+// after the validators. Broadcast also subscribes every instance to the calls made with RequestAll
 type Subscription struct {
-	Resource   rids.Pattern
-	Handler    CallHandler
-	Validators []AccessHandler
+	Resource    rids.Pattern
+	Handler     CallHandler
+	Validators  []AccessHandler
+	Middlewares []CallMiddleware
+	Broadcast   bool
+
+	// MaxInFlight limits the handlers running at once, unlimited when zero
+	MaxInFlight int
+
+	// QueueSize is how many messages wait for a handler once MaxInFlight is reached
+	QueueSize int
+
+	// Overflow applies to the messages arriving with the queue full, OverflowBlock when empty
+	Overflow OverflowPolicy
+
+	// Redelivery enables acknowledgements on monitors, overriding ProviderOptions.Redelivery. See DeadLetter
+	Redelivery *rids.RetryPolicy
+
+	// Tracker, when set, counts the messages dispatched to the handler until they are handled. See Tracker
+	Tracker *Tracker
+
+	// Ephemeral monitors of durable events only receive the events published while they are subscribed, nothing is
+	// kept for their group once they unsubscribe. Monitors with short-lived groups, like the WebSocket sessions, must
+	// be ephemeral
+	Ephemeral bool
+
+	// inFlight counts the messages dispatched by the WorkerPool, so Drain can wait for them
+	inFlight *Tracker
+
+	// group is the monitoring group of the Subscriptions made by Monitor
+	group string
+}
+
+// MonitoringGroup returns the group the Subscription was monitored by, empty when it is not a monitor
+func (s Subscription) MonitoringGroup() string {
+	return s.group
+}
+
+type monitoringGroupKey struct{}
+
+// WithMonitoringGroup returns a copy of ctx carrying the monitoring group of the event being handled
+func WithMonitoringGroup(ctx context.Context, group string) context.Context {
+	return context.WithValue(ctx, monitoringGroupKey{}, group)
+}
+
+// MonitoringGroupFromContext returns the monitoring group carried by ctx, empty when the Call is not an event
+// received by a monitor
+func MonitoringGroupFromContext(ctx context.Context) string {
+	group, _ := ctx.Value(monitoringGroupKey{}).(string)
+	return group
+}
+
+// IsDurable reports if the monitor of s by group keeps the events published while it is not subscribed
+func (s Subscription) IsDurable(group string) bool {
+	return s.Resource.Durable() && group != "" && !s.Ephemeral
 }
 
 // Event is used to declare Service events and their validators
@@ -20,6 +75,34 @@ type Event struct {
 	MonitorValidators []AccessHandler
 }
 
+// ProviderOptions configures the behaviour shared by all Provider implementations
+type ProviderOptions struct {
+	// Interceptors run in order around every Request, Publish, Monitor and Subscribe
+	Interceptors []Interceptor
+
+	// Retry is the retry policy applied to requests. A policy set on the rids.Method overrides it
+	Retry *rids.RetryPolicy
+
+	// Redelivery is the policy applied to the monitors without their own, events are delivered once when nil
+	Redelivery *rids.RetryPolicy
+
+	// IdempotentPublish derives the idempotency key of the events published without one with IdempotencyKey
+	IdempotentPublish bool
+
+	// CircuitBreaker enables a circuit breaker per endpoint name when set
+	CircuitBreaker *CircuitBreakerOptions
+
+	// Codec encodes the requests, JSONCodec when nil. Other codecs are only used with services that answered on them,
+	// so services running older versions keep receiving JSON
+	Codec Codec
+
+	// Compression enables the compression of large envelopes when set
+	Compression *CompressionOptions
+
+	// Debug logs the compression ratio of the envelopes sent
+	Debug bool
+}
+
 type ServiceHandler func(sub Subscription, payload []byte, replyEndpoint string)
 
 // Provider interface implements a multiservice communication broker that allows to listen and execute requests to
@@ -28,6 +111,11 @@ type Provider interface {
 	// Close ends the connection to the Provider
 	Close()
 
+	// Drain unsubscribes every handler and monitor, waits for the running handlers and the pending requests and
+	// publishes until ctx is done and then closes the Provider. It returns how many calls were abandoned. Only the
+	// owner of the Provider may drain it, services sharing it stop with their own Tracker
+	Drain(ctx context.Context) int
+
 	// Subscribe requests the Provider to handle a rids.Resource balancing the requests returning unsubscribe function
 	Subscribe(s Subscription, handler ServiceHandler) (func(), Error)
 
@@ -37,12 +125,51 @@ type Provider interface {
 	// Get calls a rids.Resource through the Provider without a paylod
 	Get(p rids.Pattern, rs interface{}, token ...[]byte) Error
 
+	// GetCtx works as Get but stops waiting when ctx is done. A ctx deadline overrides the Provider timeout
+	GetCtx(ctx context.Context, p rids.Pattern, rs interface{}, token ...[]byte) Error
+
 	// Request calls a rids.Resource through the Provider passing a payload
 	Request(p rids.Pattern, payload interface{}, rs interface{}, token ...[]byte) Error
 
+	// RequestCtx works as Request but stops waiting when ctx is done. A ctx deadline overrides the Provider timeout
+	RequestCtx(ctx context.Context, p rids.Pattern, payload interface{}, rs interface{}, token ...[]byte) Error
+
+	// RequestAsync works as RequestCtx without blocking, the response is available once the returned Future is done
+	RequestAsync(ctx context.Context, p rids.Pattern, payload interface{}, rs interface{}, token ...[]byte) Future
+
+	// RequestStream calls a rids.Resource receiving its response as ordered chunks, the channel is closed after the last
+	// one. Errors replied before the first chunk are returned, later ones are delivered on the last chunk. Cancel ctx
+	// to stop reading before the end of the stream
+	RequestStream(ctx context.Context, p rids.Pattern, payload interface{}, token ...[]byte) (<-chan StreamChunk, Error)
+
+	// RequestAll calls a rids.Resource on every instance subscribed with Broadcast, collecting the replies until the
+	// timeout or the expected number of replies set on opts
+	RequestAll(ctx context.Context, p rids.Pattern, payload interface{}, opts RequestAllOptions,
+		token ...[]byte) ([]InstanceReply, Error)
+
 	// Publish informs the Provider that a rids.Resource event has happened
 	Publish(p rids.Pattern, payload interface{}, token ...[]byte) Error
 
+	// PublishCtx works as Publish using ctx on the publish validation request
+	PublishCtx(ctx context.Context, p rids.Pattern, payload interface{}, token ...[]byte) Error
+
 	// Reply returns the response using a reply endpoint
 	Reply(replyEndpoint string, payload []byte) Error
+
+	// DeadLetters lists the events dead-lettered by the monitors of this Provider, oldest first. The list is
+	// process-local, it does not include the dead letters of other instances nor the ones held before a restart, see
+	// DeadLetter
+	DeadLetters() []DeadLetter
+
+	// DeadLetter returns the dead-lettered event with id, ErrorNotFound when it is not held by this Provider, as the
+	// ones dead-lettered by other instances
+	DeadLetter(id string) (DeadLetter, Error)
+
+	// ReplayDeadLetter delivers the dead-lettered event with id again to the monitor of this Provider that failed it,
+	// returning its error once the Redelivery attempts end. The event is dead-lettered again under a new id when they
+	// all fail. Dead letters of other instances are replayed by them
+	ReplayDeadLetter(id string) Error
+
+	// Status reports the health of the connections to the Spike network
+	Status() Status
 }
@@ -0,0 +1,362 @@
+package memory
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inboxPrefix starts the subjects on which requests wait for their responses
+const inboxPrefix = "_INBOX."
+
+// Bus is the in-process network of the memory Providers, routing the messages published by any of them to the
+// subscriptions of all of them. Subjects are dot separated tokens, subscriptions may use * to match any token and >
+// to match the remaining ones
+type Bus struct {
+	m        sync.Mutex
+	seq      uint64
+	inboxes  uint64
+	literal  map[string]map[uint64]*subscription
+	wildcard map[uint64]*subscription
+	durable  map[string]*durableGroup
+	dedup    map[string]time.Time
+}
+
+// NewBus returns an empty Bus. Providers on different Buses do not reach each other
+func NewBus() *Bus {
+	return &Bus{
+		literal:  make(map[string]map[uint64]*subscription),
+		wildcard: make(map[uint64]*subscription),
+		durable:  make(map[string]*durableGroup),
+		dedup:    make(map[string]time.Time),
+	}
+}
+
+var defaultBus = NewBus()
+
+type message struct {
+	subject string
+	data    []byte
+	reply   string
+
+	// published is when a durable event was published, it is zero on other messages
+	published time.Time
+}
+
+// retention limits the durable events kept by the Bus
+type retention struct {
+	dedupWindow time.Duration
+	maxAge      time.Duration
+	maxMsgs     int
+}
+
+// overflowRedeliveryDelay is how long durable events dropped by a subscription wait to be delivered again
+const overflowRedeliveryDelay = time.Second
+
+// subscription receives the messages of its subject. Subscriptions sharing a non-empty group and subject form a
+// queue group, each message is delivered to one of them
+type subscription struct {
+	id      uint64
+	subject string
+	tokens  []string
+	group   string
+	msgs    chan message
+	done    chan struct{}
+	once    sync.Once
+
+	// wait is how long deliver waits for room on msgs before dropping a message, it does not wait when zero
+	wait    time.Duration
+	dropped uint64
+
+	// durable subscriptions are members of a durableGroup
+	durable bool
+}
+
+// queue identifies the queue group of the subscription
+func (s *subscription) queue() string {
+	return s.subject + " " + s.group
+}
+
+// deliver hands msg to the subscription, waiting up to wait for room on its channel. Messages that do not fit are
+// dropped, as a slow NATS consumer does, so publishers are never held by a stuck subscription. It reports false when
+// the message is dropped or the subscription is closed
+func (s *subscription) deliver(msg message) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	select {
+	case s.msgs <- msg:
+		return true
+	case <-s.done:
+		return false
+	default:
+	}
+
+	if s.wait > 0 {
+		timer := time.NewTimer(s.wait)
+		defer timer.Stop()
+		select {
+		case s.msgs <- msg:
+			return true
+		case <-s.done:
+			return false
+		case <-timer.C:
+		}
+	}
+	atomic.AddUint64(&s.dropped, 1)
+	return false
+}
+
+// drops returns how many messages were dropped because the subscription did not keep up
+func (s *subscription) drops() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *subscription) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// durableGroup holds the durable events published while a monitoring group has no subscription
+type durableGroup struct {
+	tokens  []string
+	backlog []message
+	members int
+	idle    time.Time
+}
+
+// trim discards the events older than maxAge and the oldest ones over maxMsgs
+func (dg *durableGroup) trim(now time.Time, limits retention) {
+	expired := 0
+	for expired < len(dg.backlog) && now.Sub(dg.backlog[expired].published) > limits.maxAge {
+		expired++
+	}
+	if over := len(dg.backlog) - expired - limits.maxMsgs; over > 0 {
+		expired += over
+	}
+	dg.backlog = dg.backlog[expired:]
+}
+
+// subscribe adds a subscription on subject buffering size messages and waiting up to wait for room on it. Durable
+// subscriptions, given the retention of their group, register it so the events published while it has no
+// subscription are kept and returned to the next one
+func (b *Bus) subscribe(subject, group string, size int, wait time.Duration, durable *retention) (*subscription,
+	[]message) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.seq++
+	sub := &subscription{
+		id:      b.seq,
+		subject: subject,
+		tokens:  strings.Split(subject, "."),
+		group:   group,
+		msgs:    make(chan message, size),
+		done:    make(chan struct{}),
+		wait:    wait,
+		durable: durable != nil,
+	}
+	if isLiteral(sub.tokens) {
+		if b.literal[subject] == nil {
+			b.literal[subject] = make(map[uint64]*subscription)
+		}
+		b.literal[subject][sub.id] = sub
+	} else {
+		b.wildcard[sub.id] = sub
+	}
+
+	if durable == nil {
+		return sub, nil
+	}
+	now := time.Now()
+	dg, ok := b.durable[sub.queue()]
+	if !ok || (dg.members == 0 && now.Sub(dg.idle) > durable.maxAge) {
+		dg = &durableGroup{tokens: sub.tokens}
+		b.durable[sub.queue()] = dg
+	}
+	dg.members++
+	dg.trim(now, *durable)
+	backlog := dg.backlog
+	dg.backlog = nil
+	return sub, backlog
+}
+
+func (b *Bus) unsubscribe(sub *subscription) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	subs, literal := b.literal[sub.subject]
+	_, wildcard := b.wildcard[sub.id]
+	if _, ok := subs[sub.id]; !ok && !wildcard {
+		return
+	}
+	if literal {
+		delete(subs, sub.id)
+		if len(subs) == 0 {
+			delete(b.literal, sub.subject)
+		}
+	}
+	delete(b.wildcard, sub.id)
+
+	if dg, ok := b.durable[sub.queue()]; ok && sub.durable {
+		dg.members--
+		if dg.members == 0 {
+			dg.idle = time.Now()
+		}
+	}
+}
+
+// inbox returns a new subject to receive the responses of a request
+func (b *Bus) inbox() string {
+	return fmt.Sprintf("%s%d", inboxPrefix, atomic.AddUint64(&b.inboxes, 1))
+}
+
+// publish delivers msg to every subscription without a group and to one subscription of each queue group, returning
+// how many received it. Requests no subscription received fail with ErrorServiceUnavailable
+func (b *Bus) publish(msg message) int {
+	b.m.Lock()
+	targets, _ := b.route(msg.subject)
+	b.m.Unlock()
+	return deliver(targets, msg)
+}
+
+// publishDurable works as publish keeping msg for the durable groups without subscriptions within the retention
+// limits. Groups without subscriptions for longer than the retention max age are forgotten. Messages with a msgID
+// seen within the retention dedup window are discarded
+func (b *Bus) publishDurable(msg message, msgID string, limits retention) int {
+	b.m.Lock()
+	now := time.Now()
+	for id, expires := range b.dedup {
+		if now.After(expires) {
+			delete(b.dedup, id)
+		}
+	}
+	if msgID != "" {
+		if _, seen := b.dedup[msgID]; seen {
+			b.m.Unlock()
+			return 0
+		}
+		b.dedup[msgID] = now.Add(limits.dedupWindow)
+	}
+
+	msg.published = now
+	targets, served := b.route(msg.subject)
+	tokens := strings.Split(msg.subject, ".")
+	for queue, dg := range b.durable {
+		if dg.members == 0 && now.Sub(dg.idle) > limits.maxAge {
+			delete(b.durable, queue)
+			continue
+		}
+		if !served[queue] && matches(dg.tokens, tokens) {
+			dg.backlog = append(dg.backlog, msg)
+		}
+		dg.trim(now, limits)
+	}
+	b.m.Unlock()
+
+	delivered := 0
+	for _, sub := range targets {
+		switch {
+		case sub.deliver(msg):
+			delivered++
+		case sub.durable:
+			b.redeliver(sub.queue(), msg)
+		}
+	}
+	return delivered
+}
+
+// redeliver delivers a durable event dropped by a member of the queue group to the group again after
+// overflowRedeliveryDelay, as JetStream does. It is kept for the group when it has no subscription by then
+func (b *Bus) redeliver(queue string, msg message) {
+	time.AfterFunc(overflowRedeliveryDelay, func() {
+		b.m.Lock()
+		var member *subscription
+		targets, _ := b.route(msg.subject)
+		for _, sub := range targets {
+			if sub.durable && sub.queue() == queue {
+				member = sub
+			}
+		}
+		if member == nil {
+			if dg, ok := b.durable[queue]; ok {
+				dg.backlog = append(dg.backlog, msg)
+			}
+			b.m.Unlock()
+			return
+		}
+		b.m.Unlock()
+
+		if !member.deliver(msg) {
+			b.redeliver(queue, msg)
+		}
+	})
+}
+
+// route picks the subscriptions receiving a message on subject, choosing a random member of each queue group. It
+// also returns the queue groups served
+func (b *Bus) route(subject string) ([]*subscription, map[string]bool) {
+	var targets []*subscription
+	groups := make(map[string][]*subscription)
+	add := func(sub *subscription) {
+		if sub.group == "" {
+			targets = append(targets, sub)
+			return
+		}
+		groups[sub.queue()] = append(groups[sub.queue()], sub)
+	}
+
+	for _, sub := range b.literal[subject] {
+		add(sub)
+	}
+	if len(b.wildcard) > 0 {
+		tokens := strings.Split(subject, ".")
+		for _, sub := range b.wildcard {
+			if matches(sub.tokens, tokens) {
+				add(sub)
+			}
+		}
+	}
+
+	served := make(map[string]bool, len(groups))
+	for queue, members := range groups {
+		targets = append(targets, members[rand.Intn(len(members))])
+		served[queue] = true
+	}
+	return targets, served
+}
+
+func deliver(targets []*subscription, msg message) int {
+	delivered := 0
+	for _, sub := range targets {
+		if sub.deliver(msg) {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+func isLiteral(tokens []string) bool {
+	for _, token := range tokens {
+		if token == "*" || token == ">" {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports if the subject tokens match the subscription tokens
+func matches(pattern, subject []string) bool {
+	for i, token := range pattern {
+		if token == ">" {
+			return len(subject) > i
+		}
+		if i >= len(subject) || (token != "*" && token != subject[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(subject)
+}
@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/spike-events/spike-broker/v2/pkg/broker"
+)
+
+const (
+	// MaxChans is the default buffer of the subscription channels
+	MaxChans = 100
+	// MaxInbox is the default buffer of the request response channels
+	MaxInbox = 50
+	// DefaultTimeout is how long requests wait for a response when Config.Timeout is not set
+	DefaultTimeout = 30 * time.Second
+	// DefaultDedupWindow is how long durable events are remembered when Config.DedupWindow is not set
+	DefaultDedupWindow = 2 * time.Minute
+	// DefaultDurableMaxAge is how long durable events are kept when Config.DurableMaxAge is not set
+	DefaultDurableMaxAge = 7 * 24 * time.Hour
+	// DefaultDurableMaxMsgs is how many durable events a monitoring group keeps when Config.DurableMaxMsgs is not set
+	DefaultDurableMaxMsgs = 10000
+)
+
+type Config struct {
+	// Bus is the network shared with the other Providers of the process, the default Bus when nil
+	Bus *Bus
+
+	// ChannelSize buffers the messages of each subscription, MaxChans when zero. Once it is full publishers wait up to
+	// the Timeout for room, or not at all for subscriptions with OverflowDrop or OverflowReject, and the message is
+	// dropped. Durable events dropped by a monitoring group, here or by its Overflow, are delivered to it again
+	ChannelSize int
+
+	// InboxSize buffers the responses of each request, MaxInbox when zero. Requests whose responses overflow it fail
+	// with broker.ErrorStreamOverflow
+	InboxSize int
+
+	// Timeout is how long requests wait for a response when their context has no deadline, DefaultTimeout when zero
+	Timeout time.Duration
+
+	// DedupWindow is how long the idempotency key of durable events is remembered, DefaultDedupWindow when zero
+	DedupWindow time.Duration
+
+	// DurableMaxAge and DurableMaxMsgs limit the durable events kept for a monitoring group without subscriptions,
+	// the oldest ones are discarded first. Groups without subscriptions for longer than DurableMaxAge are forgotten.
+	// DefaultDurableMaxAge and DefaultDurableMaxMsgs when zero
+	DurableMaxAge  time.Duration
+	DurableMaxMsgs int
+
+	// ProviderOptions configures the behaviour shared with other Provider implementations
+	ProviderOptions broker.ProviderOptions
+}
@@ -0,0 +1,314 @@
+// Package memory implements a Provider routing the calls and events within the process, so every service can run in
+// a single binary without a broker. Providers built on the same Bus reach each other as if connected to one server
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/spike-events/spike-broker/v2/pkg/broker"
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+)
+
+// ErrClosed is returned by the Providers used after Close
+var ErrClosed = errors.New("memory: provider closed")
+
+var paramSubject = regexp.MustCompile("\\$[^.]+")
+
+// NewMemoryProvider builds a new Provider on the Config Bus
+func NewMemoryProvider(config Config) broker.Provider {
+	if config.Bus == nil {
+		config.Bus = defaultBus
+	}
+	if config.ChannelSize <= 0 {
+		config.ChannelSize = MaxChans
+	}
+	if config.InboxSize <= 0 {
+		config.InboxSize = MaxInbox
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.DedupWindow <= 0 {
+		config.DedupWindow = DefaultDedupWindow
+	}
+	if config.DurableMaxAge <= 0 {
+		config.DurableMaxAge = DefaultDurableMaxAge
+	}
+	if config.DurableMaxMsgs <= 0 {
+		config.DurableMaxMsgs = DefaultDurableMaxMsgs
+	}
+
+	return broker.NewSpecific(&Provider{
+		config: config,
+		bus:    config.Bus,
+		subs:   make(map[*subscription]bool),
+	}, config.ProviderOptions)
+}
+
+type Provider struct {
+	config Config
+	bus    *Bus
+	m      sync.Mutex
+	subs   map[*subscription]bool
+	closed bool
+}
+
+// SubscribeRaw subscribes the specific endpoint of sub on the Bus. Subscriptions of durable Patterns with a group,
+// unless ephemeral, receive the events published while the group had no subscription
+func (s *Provider) SubscribeRaw(sub broker.Subscription, group string, handler broker.ServiceHandler) (func(),
+	broker.Error) {
+	s.m.Lock()
+	if s.closed {
+		s.m.Unlock()
+		return nil, broker.InternalError(ErrClosed)
+	}
+	subject := paramSubject.ReplaceAllString(sub.Resource.EndpointNameSpecific(), "*")
+	// Subscriptions dropping or rejecting their overflow do not hold the publishers, the others hold them up to the
+	// Timeout
+	var wait time.Duration
+	if sub.Overflow != broker.OverflowDrop && sub.Overflow != broker.OverflowReject {
+		wait = s.config.Timeout
+	}
+	var durable *retention
+	if sub.IsDurable(group) {
+		limits := s.retention()
+		durable = &limits
+	}
+	busSub, backlog := s.bus.subscribe(subject, group, s.config.ChannelSize, wait, durable)
+	s.subs[busSub] = true
+	s.m.Unlock()
+
+	dispatched := make(chan struct{})
+	go s.dispatch(sub, handler, busSub, dispatched)
+	for _, msg := range backlog {
+		if !busSub.deliver(msg) {
+			s.bus.redeliver(busSub.queue(), msg)
+		}
+	}
+
+	return func() {
+		s.unsubscribe(busSub)
+		<-dispatched
+	}, nil
+}
+
+// dispatch hands the messages of busSub to a WorkerPool until it is closed, closing dispatched once the messages
+// still buffered are handed as well
+func (s *Provider) dispatch(sub broker.Subscription, handler broker.ServiceHandler, busSub *subscription,
+	dispatched chan struct{}) {
+	pool := broker.NewWorkerPool(sub, handler, func(replyEndpoint string) {
+		if err := s.PublishRaw(replyEndpoint, broker.ErrorServiceUnavailable.ToJSON()); err != nil {
+			log.Printf("memory: failed to reject message on endpoint %s: %v", busSub.subject, err)
+		}
+	})
+	defer pool.Close()
+	defer close(dispatched)
+
+	handle := func(msg message) {
+		if !busSub.durable {
+			pool.Dispatch(msg.data, msg.reply)
+			return
+		}
+		// Durable events dropped by the Subscription Overflow are delivered to the group again
+		pool.DispatchFunc(func() {
+			handler(sub, msg.data, msg.reply)
+		}, func() {
+			s.bus.redeliver(busSub.queue(), msg)
+		})
+	}
+	for {
+		select {
+		case msg := <-busSub.msgs:
+			handle(msg)
+		case <-busSub.done:
+			for {
+				select {
+				case msg := <-busSub.msgs:
+					handle(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Provider) unsubscribe(busSub *subscription) {
+	s.m.Lock()
+	delete(s.subs, busSub)
+	s.m.Unlock()
+	s.bus.unsubscribe(busSub)
+	busSub.close()
+}
+
+// Close removes the subscriptions left on the Bus, the Provider cannot be used afterwards
+func (s *Provider) Close() {
+	s.m.Lock()
+	s.closed = true
+	subs := s.subs
+	s.subs = make(map[*subscription]bool)
+	s.m.Unlock()
+
+	for busSub := range subs {
+		s.bus.unsubscribe(busSub)
+		busSub.close()
+	}
+}
+
+// Status reports the Provider healthy until it is closed, the Bus being its only connection
+func (s *Provider) Status() broker.Status {
+	if s.isClosed() {
+		return broker.Status{Connections: 1, LastError: ErrClosed.Error()}
+	}
+	return broker.Status{Healthy: true, Connections: 1, Connected: 1}
+}
+
+func (s *Provider) isClosed() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.closed
+}
+
+func (s *Provider) PublishRaw(subject string, data []byte) broker.Error {
+	if s.isClosed() {
+		return broker.InternalError(ErrClosed)
+	}
+	s.bus.publish(message{subject: subject, data: data})
+	return nil
+}
+
+// PublishDurableRaw publishes the event keeping it for the monitoring groups of durable Patterns without a
+// subscription, within the Config DurableMaxAge and DurableMaxMsgs. Events with the same msgID within the Config
+// DedupWindow are discarded
+func (s *Provider) PublishDurableRaw(p rids.Pattern, data []byte, msgID string) broker.Error {
+	if s.isClosed() {
+		return broker.InternalError(ErrClosed)
+	}
+	s.bus.publishDurable(message{subject: p.EndpointNameSpecific(), data: data}, msgID, s.retention())
+	return nil
+}
+
+// retention returns the limits of the durable events set on the Config
+func (s *Provider) retention() retention {
+	return retention{
+		dedupWindow: s.config.DedupWindow,
+		maxAge:      s.config.DurableMaxAge,
+		maxMsgs:     s.config.DurableMaxMsgs,
+	}
+}
+
+func (s *Provider) RequestRaw(ctx context.Context, subject string, data []byte,
+	overrideTimeout ...time.Duration) ([]byte, broker.Error) {
+	var rs []byte
+	rErr := s.request(ctx, subject, data, func(msg []byte) bool {
+		rs = msg
+		return false
+	}, overrideTimeout...)
+	if rErr != nil {
+		return nil, rErr
+	}
+	return rs, nil
+}
+
+func (s *Provider) RequestStreamRaw(ctx context.Context, subject string, data []byte,
+	handler func([]byte) bool) broker.Error {
+	return s.request(ctx, subject, data, handler)
+}
+
+func (s *Provider) RequestAllRaw(ctx context.Context, subject string, data []byte,
+	handler func([]byte) bool) broker.Error {
+	return s.request(ctx, subject, data, handler)
+}
+
+// request publishes data on subject and passes the responses to handler until it returns false. Requests nobody is
+// subscribed to fail right away with ErrorServiceUnavailable
+func (s *Provider) request(ctx context.Context, subject string, data []byte, handler func([]byte) bool,
+	overrideTimeout ...time.Duration) broker.Error {
+	if ctxErr := broker.ErrorFromContext(ctx); ctxErr != nil {
+		return ctxErr
+	}
+	if s.isClosed() {
+		return broker.InternalError(ErrClosed)
+	}
+
+	inbox, _ := s.bus.subscribe(s.bus.inbox(), "", s.config.InboxSize, 0, nil)
+	defer func() {
+		s.bus.unsubscribe(inbox)
+		inbox.close()
+	}()
+
+	if s.bus.publish(message{subject: subject, data: data, reply: inbox.subject}) == 0 {
+		return broker.ErrorServiceUnavailable
+	}
+
+	t := s.config.Timeout
+	if len(overrideTimeout) > 0 {
+		t = overrideTimeout[0]
+	}
+	for {
+		if deadline, ok := ctx.Deadline(); ok {
+			t = time.Until(deadline)
+		}
+		rs, rErr := s.processResponse(ctx, subject, inbox, t)
+		if inbox.drops() > 0 {
+			// Responses were lost because the caller did not keep up, the following ones are incomplete
+			return broker.ErrorStreamOverflow
+		}
+		if rErr != nil {
+			return rErr
+		}
+		if !handler(rs) {
+			return nil
+		}
+	}
+}
+
+// processResponse waits for the next response on inbox, extending the timeout and notifying the progress sent on
+// control frames
+func (s *Provider) processResponse(ctx context.Context, subject string, inbox *subscription,
+	t time.Duration) ([]byte, broker.Error) {
+	for {
+		timer := time.NewTimer(t)
+		select {
+		case msg := <-inbox.msgs:
+			timer.Stop()
+			ctl, rErr := broker.DecodeControl(msg.data)
+			if rErr != nil {
+				return nil, rErr
+			}
+			if ctl != nil {
+				if ctl.Kind == broker.ControlExtend {
+					t = ctl.Timeout
+				} else {
+					broker.NotifyProgress(ctx, ctl.Data)
+				}
+				break
+			}
+			if len(msg.data) == 0 {
+				return nil, broker.InternalError(fmt.Errorf("memory: empty reply on %s", subject))
+			}
+			return msg.data, nil
+
+		case <-timer.C:
+			return nil, broker.ErrorTimeout
+
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, broker.ErrorFromContext(ctx)
+		}
+	}
+}
+
+func (s *Provider) Timeout() time.Duration {
+	return s.config.Timeout
+}
+
+func (s *Provider) NewCall(p rids.Pattern, payload interface{}) broker.Call {
+	return broker.NewCall(p, payload)
+}
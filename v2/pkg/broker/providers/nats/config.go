@@ -1,6 +1,11 @@
 package nats
 
-import "github.com/spike-events/spike-broker/v2/pkg/service"
+import (
+	"time"
+
+	"github.com/spike-events/spike-broker/v2/pkg/broker"
+	"github.com/spike-events/spike-broker/v2/pkg/service"
+)
 
 type Config struct {
 	LocalNats      bool
@@ -9,4 +14,56 @@ type Config struct {
 	NatsURL        string
 	DebugLevel     int
 	Logger         service.Logger
+
+	// LocalServer configures the local server started when LocalNats is set
+	LocalServer ServerConfig
+
+	// TLS enables TLS on the connections
+	TLS *TLSConfig
+
+	// NKeySeedFile authenticates the connections with the NKey seed stored on the file
+	NKeySeedFile string
+
+	// CredsFile authenticates the connections with the user JWT and NKey seed of a .creds file
+	CredsFile string
+
+	// User and Password authenticate the connections when User is set
+	User     string
+	Password string
+
+	// Connections is the size of the connection pool owned by the Provider, MaxConns when zero
+	Connections int
+
+	// ChannelSize buffers the messages of each subscription, MaxChans when zero
+	ChannelSize int
+
+	// InboxSize buffers the responses of each request, MaxInbox when zero. Requests whose responses overflow it fail
+	// with broker.ErrorStreamOverflow
+	InboxSize int
+
+	// Timeout is how long requests wait for a response when their context has no deadline. When zero it is read in
+	// milliseconds from the TIMEOUT environment variable, defaulting to 30 seconds
+	Timeout time.Duration
+
+	// AckWait is how long a durable event waits for the monitor handler to return before being redelivered, 30
+	// seconds when zero
+	AckWait time.Duration
+
+	// DedupWindow is how long the streams created by the Provider remember the idempotency key of durable events,
+	// the JetStream default of 2 minutes when zero
+	DedupWindow time.Duration
+
+	// DurableMaxAge, DurableMaxBytes and DurableMaxMsgs limit the durable events kept by the streams of the Provider,
+	// the oldest ones are discarded first. DurableMaxAge is DefaultDurableMaxAge when zero, the size and count are
+	// unlimited when zero
+	DurableMaxAge   time.Duration
+	DurableMaxBytes int64
+	DurableMaxMsgs  int64
+
+	// LifecycleHandlers are called when a connection of the pool disconnects, reconnects, closes or reports an
+	// asynchronous error, like a slow consumer
+	LifecycleHandlers []LifecycleHandler
+
+	// ProviderOptions configures the behaviour shared with other Provider implementations
+	ProviderOptions broker.ProviderOptions
 }
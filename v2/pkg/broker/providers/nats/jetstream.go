@@ -0,0 +1,211 @@
+package nats
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spike-events/spike-broker/v2/pkg/broker"
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+)
+
+var paramSubject = regexp.MustCompile("\\$[^.]+")
+
+// jsName turns a subject or group into a valid JetStream stream or consumer name
+var jsName = strings.NewReplacer(".", "_", "*", "-", ">", "-", " ", "_")
+
+// streamName is the stream holding the durable events of a service
+func streamName(service string) string {
+	return "spike_" + jsName.Replace(service)
+}
+
+// PublishDurableRaw stores the event on the JetStream stream of the pattern service, creating it when needed. The
+// msgID is sent as Nats-Msg-Id, so JetStream discards the events repeated within the Config DedupWindow
+func (s *Provider) PublishDurableRaw(p rids.Pattern, data []byte, msgID string) broker.Error {
+	s.printDebug("nats: publishing durable endpoint %s", p.EndpointNameSpecific())
+	bus := s.requestConn()
+	defer s.releaseConn(bus)
+
+	js, err := bus.JetStream()
+	if err != nil {
+		return broker.InternalError(err)
+	}
+	if _, err = s.ensureStream(js, p); err != nil {
+		return broker.InternalError(err)
+	}
+	var opts []nats.PubOpt
+	if msgID != "" {
+		opts = append(opts, nats.MsgId(msgID))
+	}
+	if _, err = js.Publish(p.EndpointNameSpecific(), data, opts...); err != nil {
+		return broker.InternalError(err)
+	}
+	return nil
+}
+
+// ensureStream makes sure the stream of the pattern service captures its subject, returning the stream name
+func (s *Provider) ensureStream(js nats.JetStreamContext, p rids.Pattern) (string, error) {
+	s.streamsM.Lock()
+	defer s.streamsM.Unlock()
+
+	name := streamName(p.Service())
+	subject := paramSubject.ReplaceAllString(p.EndpointName(), "*")
+	if s.streams[name+" "+subject] {
+		return name, nil
+	}
+
+	info, err := js.StreamInfo(name)
+	switch {
+	case errors.Is(err, nats.ErrStreamNotFound):
+		config := &nats.StreamConfig{
+			Name:       name,
+			Subjects:   []string{subject},
+			Storage:    nats.FileStorage,
+			Duplicates: s.config.DedupWindow,
+		}
+		s.limitStream(config)
+		_, err = js.AddStream(config)
+	case err == nil:
+		found := false
+		for _, streamSubject := range info.Config.Subjects {
+			found = found || streamSubject == subject
+		}
+		if !found {
+			info.Config.Subjects = append(info.Config.Subjects, subject)
+		}
+		// Streams created by older versions or with other limits are updated to the configured ones
+		if s.limitStream(&info.Config) || !found {
+			_, err = js.UpdateStream(&info.Config)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if s.streams == nil {
+		s.streams = make(map[string]bool)
+	}
+	s.streams[name+" "+subject] = true
+	return name, nil
+}
+
+// limitStream applies the Config retention limits to the stream config, reporting if they changed
+func (s *Provider) limitStream(config *nats.StreamConfig) bool {
+	maxAge := s.config.DurableMaxAge
+	// JetStream reads negative sizes and counts as unlimited
+	maxBytes, maxMsgs := s.config.DurableMaxBytes, s.config.DurableMaxMsgs
+	if maxBytes <= 0 {
+		maxBytes = -1
+	}
+	if maxMsgs <= 0 {
+		maxMsgs = -1
+	}
+	changed := config.MaxAge != maxAge || config.MaxBytes != maxBytes || config.MaxMsgs != maxMsgs
+	config.MaxAge, config.MaxBytes, config.MaxMsgs = maxAge, maxBytes, maxMsgs
+	return changed
+}
+
+func (s *Provider) SubscribeDurableRaw(sub broker.Subscription, group string, handler broker.DurableHandler) (func(),
+	broker.Error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.subscribeDurable(sub, group, handler)
+}
+
+// subscribeDurable binds to the durable consumer of the monitoring group, creating it when needed. Each event is
+// settled as its handler returns. Events dropped by the Subscription Overflow are redelivered after
+// overflowRedeliveryDelay, and the ones not settled within the AckWait are redelivered by JetStream
+func (s *Provider) subscribeDurable(sub broker.Subscription, group string, handler broker.DurableHandler) (func(),
+	broker.Error) {
+	bus := s.requestConn()
+	defer s.releaseConn(bus)
+
+	js, err := bus.JetStream()
+	if err != nil {
+		return nil, broker.InternalError(err)
+	}
+	stream, err := s.ensureStream(js, sub.Resource)
+	if err != nil {
+		return nil, broker.InternalError(err)
+	}
+
+	subject := paramSubject.ReplaceAllString(sub.Resource.EndpointNameSpecific(), "*")
+	consumer := jsName.Replace(group + "_" + subject)
+	if _, err = js.ConsumerInfo(stream, consumer); errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = js.AddConsumer(stream, &nats.ConsumerConfig{
+			Durable:        consumer,
+			DeliverSubject: nats.NewInbox(),
+			DeliverGroup:   consumer,
+			DeliverPolicy:  nats.DeliverNewPolicy,
+			FilterSubject:  subject,
+			AckPolicy:      nats.AckExplicitPolicy,
+			AckWait:        s.config.AckWait,
+		})
+	}
+	if err != nil {
+		return nil, broker.InternalError(err)
+	}
+
+	msgs := make(chan *nats.Msg, s.config.ChannelSize)
+	jsSub, err := js.ChanQueueSubscribe(subject, consumer, msgs, nats.Bind(stream, consumer), nats.ManualAck())
+	if err != nil {
+		return nil, broker.InternalError(err)
+	}
+
+	dispatched := make(chan struct{})
+	go func() {
+		pool := broker.NewWorkerPool(sub, nil, nil)
+		defer pool.Close()
+		defer close(dispatched)
+		for msg := range msgs {
+			msg := msg
+			attempt := 1
+			if meta, err := msg.Metadata(); err == nil {
+				attempt = int(meta.NumDelivered)
+			}
+			pool.DispatchFunc(func() {
+				kind, delay := handler(sub, msg.Data, attempt)
+				settle(msg, kind, delay)
+			}, func() {
+				settle(msg, broker.AckRetry, overflowRedeliveryDelay)
+			})
+		}
+	}()
+
+	s.printDebug("nats: durable consumer %s subscribed on %s", consumer, subject)
+	return func() {
+		if err := jsSub.Unsubscribe(); err != nil {
+			log.Printf("nats: failed to unsubscribe durable consumer %s: %v", consumer, err)
+		}
+		close(msgs)
+		<-dispatched
+	}, nil
+}
+
+// settle acknowledges, redelivers or terminates a durable event
+func settle(msg *nats.Msg, kind broker.AckKind, delay time.Duration) {
+	var err error
+	switch kind {
+	case broker.AckRetry:
+		err = msg.NakWithDelay(delay)
+	case broker.AckTerm:
+		err = msg.Term()
+	default:
+		err = msg.Ack()
+	}
+	if err != nil {
+		log.Printf("nats: failed to settle durable event on %s: %v", msg.Subject, err)
+	}
+}
+
+// overflowRedeliveryDelay is how long durable events dropped by the Subscription Overflow wait to be delivered again
+const overflowRedeliveryDelay = time.Second
+
+// DefaultDurableMaxAge is how long the streams keep the durable events when Config.DurableMaxAge is not set
+const DefaultDurableMaxAge = 7 * 24 * time.Hour
+
+// defaultAckWait is how long durable events wait for the acknowledgement when Config.AckWait is not set
+const defaultAckWait = 30 * time.Second
@@ -0,0 +1,141 @@
+package nats
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spike-events/spike-broker/v2/pkg/broker"
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+)
+
+// LifecycleKind is the kind of change reported by a LifecycleEvent
+type LifecycleKind string
+
+const (
+	LifecycleDisconnected LifecycleKind = "disconnected"
+	LifecycleReconnected  LifecycleKind = "reconnected"
+	LifecycleClosed       LifecycleKind = "closed"
+	LifecycleSlowConsumer LifecycleKind = "slowConsumer"
+	LifecycleAsyncError   LifecycleKind = "asyncError"
+)
+
+// LifecycleEvent reports a change on one of the connections of the Provider. It is passed to the Config
+// LifecycleHandlers and published on rids.Spike().EventConnectionChanged. LifecycleClosed is reported once, when the
+// Provider closes
+type LifecycleEvent struct {
+	Kind LifecycleKind `json:"kind"`
+
+	// URL is the server the connection is bound to, empty while disconnected
+	URL string `json:"url,omitempty"`
+
+	// Subject is the subscription that failed on slow consumer and async errors
+	Subject string `json:"subject,omitempty"`
+
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// LifecycleHandler is called with the lifecycle events of the Provider connections
+type LifecycleHandler func(event LifecycleEvent)
+
+// lifecycleOptions registers the callbacks reporting the lifecycle of a connection
+func (s *Provider) lifecycleOptions(opts *nats.Options) {
+	opts.DisconnectedErrCB = func(conn *nats.Conn, err error) {
+		s.notify(conn, LifecycleDisconnected, nil, err)
+	}
+	opts.ReconnectedCB = func(conn *nats.Conn) {
+		s.notify(conn, LifecycleReconnected, nil, nil)
+	}
+	opts.ClosedCB = func(conn *nats.Conn) {
+		s.notify(conn, LifecycleClosed, nil, nil)
+	}
+	opts.AsyncErrorCB = func(conn *nats.Conn, sub *nats.Subscription, err error) {
+		if errors.Is(err, nats.ErrSlowConsumer) {
+			s.notify(conn, LifecycleSlowConsumer, sub, err)
+			return
+		}
+		s.notify(conn, LifecycleAsyncError, sub, err)
+	}
+}
+
+// notify records the last error and reports the event of a connection. Once Close starts the events of the
+// connections it closes are skipped, and the Provider reports it is closed once
+func (s *Provider) notify(conn *nats.Conn, kind LifecycleKind, sub *nats.Subscription, err error) {
+	if atomic.LoadInt32(&s.closing) == 1 {
+		return
+	}
+
+	event := LifecycleEvent{
+		Kind: kind,
+		URL:  conn.ConnectedUrlRedacted(),
+		Time: time.Now(),
+	}
+	if sub != nil {
+		event.Subject = sub.Subject
+	}
+	if err != nil {
+		event.Error = err.Error()
+		s.statusM.Lock()
+		s.lastError = event.Error
+		s.statusM.Unlock()
+	}
+	if kind == LifecycleSlowConsumer || kind == LifecycleAsyncError {
+		log.Printf("nats: %s on %s: %v", kind, event.Subject, err)
+	} else {
+		s.printDebug("nats: connection %s: %s", kind, event.Error)
+	}
+
+	if kind == LifecycleClosed {
+		s.closedOnce.Do(func() {
+			s.report(event)
+		})
+		return
+	}
+	s.report(event)
+}
+
+// notifyClosed marks the Provider as closing and reports it once, while its connections can still publish the event
+func (s *Provider) notifyClosed() {
+	atomic.StoreInt32(&s.closing, 1)
+	s.closedOnce.Do(func() {
+		s.report(LifecycleEvent{Kind: LifecycleClosed, URL: s.config.NatsURL, Time: time.Now()})
+	})
+}
+
+// report passes the event to the handlers and publishes it. It is published with PublishRaw, skipping the
+// interceptors and validations of the Provider
+func (s *Provider) report(event LifecycleEvent) {
+	for _, handler := range s.config.LifecycleHandlers {
+		handler(event)
+	}
+
+	p := rids.Spike().EventConnectionChanged()
+	if rErr := s.PublishRaw(p.EndpointNameSpecific(), s.NewCall(p, event).ToJSON()); rErr != nil {
+		s.printDebug("nats: failed to publish connection %s event: %v", event.Kind, rErr)
+	}
+}
+
+// Status reports how many connections of the pool are up, the data they have buffered and the last connection error
+func (s *Provider) Status() broker.Status {
+	status := broker.Status{}
+	if s.pool != nil {
+		for _, conn := range s.pool.all() {
+			status.Connections++
+			if conn.IsConnected() {
+				status.Connected++
+			}
+			if pending, err := conn.Buffered(); err == nil {
+				status.PendingBytes += pending
+			}
+		}
+	}
+	status.Healthy = status.Connections > 0 && status.Connected == status.Connections
+
+	s.statusM.Lock()
+	status.LastError = s.lastError
+	s.statusM.Unlock()
+	return status
+}
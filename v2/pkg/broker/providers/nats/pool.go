@@ -0,0 +1,99 @@
+package nats
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// connPool holds the connections of a Provider, handing out the least used one
+type connPool struct {
+	m     sync.Mutex
+	conns []*nats.Conn
+	inUse []int
+	next  int
+}
+
+func newConnPool(size int, connect func() (*nats.Conn, error)) (*connPool, error) {
+	pool := &connPool{
+		conns: make([]*nats.Conn, 0, size),
+		inUse: make([]int, size),
+	}
+	for i := 0; i < size; i++ {
+		conn, err := connect()
+		if err != nil {
+			pool.close()
+			return nil, err
+		}
+		pool.conns = append(pool.conns, conn)
+	}
+	return pool, nil
+}
+
+// acquire returns the connection with fewer requests in progress, starting after the last one handed out so idle
+// connections are used in turns. It must be given back with release
+func (p *connPool) acquire() *nats.Conn {
+	p.m.Lock()
+	defer p.m.Unlock()
+	chosen := p.next
+	for i := 1; i < len(p.conns); i++ {
+		candidate := (p.next + i) % len(p.conns)
+		if p.inUse[candidate] < p.inUse[chosen] {
+			chosen = candidate
+		}
+	}
+	p.inUse[chosen]++
+	p.next = (chosen + 1) % len(p.conns)
+	return p.conns[chosen]
+}
+
+func (p *connPool) release(conn *nats.Conn) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	for i := range p.conns {
+		if p.conns[i] == conn {
+			p.inUse[i]--
+			return
+		}
+	}
+}
+
+// pinned returns the connection assigned to key, so the messages published with the same key keep their order. It
+// must not be given back with release
+func (p *connPool) pinned(key string) *nats.Conn {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return p.conns[hash.Sum32()%uint32(len(p.conns))]
+}
+
+// all returns every connection of the pool
+func (p *connPool) all() []*nats.Conn {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return append([]*nats.Conn(nil), p.conns...)
+}
+
+// drain drains every connection, waiting until the pending messages are flushed and the connections closed or the
+// drain timeout of the connection ends
+func (p *connPool) drain() {
+	conns := p.all()
+	for _, conn := range conns {
+		if err := conn.Drain(); err != nil {
+			// Closed or reconnecting connections cannot be drained
+			conn.Close()
+		}
+	}
+	for _, conn := range conns {
+		for !conn.IsClosed() {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func (p *connPool) close() {
+	for _, conn := range p.all() {
+		conn.Close()
+	}
+}
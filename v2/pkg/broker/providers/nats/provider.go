@@ -2,7 +2,7 @@ package nats
 
 import "C"
 import (
-	"container/ring"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -20,81 +20,133 @@ import (
 )
 
 const (
+	// MaxChans is the default buffer of the subscription channels
 	MaxChans = 100
+	// MaxConns is the default size of the connection pool
 	MaxConns = 20
+	// MaxInbox is the default buffer of the request response channels
+	MaxInbox = 50
 )
 
-var globalTimeout time.Duration
-var globalConnections *ring.Ring
-var m sync.Mutex
-var connMutex sync.Mutex
-
-func init() {
-	t := os.Getenv("TIMEOUT")
-	tParse, err := strconv.Atoi(t)
-	if err == nil {
-		globalTimeout = time.Duration(tParse) * time.Millisecond
-	} else {
-		globalTimeout = 30 * time.Second
+// defaultTimeout reads the request timeout in milliseconds from the TIMEOUT environment variable, 30s when unset
+func defaultTimeout() time.Duration {
+	t, err := strconv.Atoi(os.Getenv("TIMEOUT"))
+	if err != nil {
+		return 30 * time.Second
 	}
+	return time.Duration(t) * time.Millisecond
 }
 
+// NewNatsProvider connects a new Provider with its own connection pool, so many of them can run in one process
 func NewNatsProvider(config Config) broker.Provider {
+	provider, _ := NewNatsProviderWithURL(config)
+	return provider
+}
+
+// NewNatsProviderWithURL works as NewNatsProvider, also returning the URL the connections use. When LocalNats is set
+// it is the one the local server is bound to, letting other Providers connect to a server listening on a random port
+func NewNatsProviderWithURL(config Config) (broker.Provider, string) {
+	if config.Connections <= 0 {
+		config.Connections = MaxConns
+	}
+	if config.ChannelSize <= 0 {
+		config.ChannelSize = MaxChans
+	}
+	if config.InboxSize <= 0 {
+		config.InboxSize = MaxInbox
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout()
+	}
+	if config.AckWait <= 0 {
+		config.AckWait = defaultAckWait
+	}
+	if config.DurableMaxAge <= 0 {
+		config.DurableMaxAge = DefaultDurableMaxAge
+	}
+
 	natsConn := &Provider{
 		config: config,
 	}
 
-	m.Lock()
-	defer m.Unlock()
-
 	if config.LocalNats {
-		opts := &defaultNatsOptions
-		opts.Debug = config.LocalNatsDebug
-		opts.Trace = config.LocalNatsTrace
-		natsConn.localNats = runServer(opts)
+		opts, err := config.LocalServer.options(config.LocalNatsDebug, config.LocalNatsTrace)
+		if err != nil {
+			panic(err)
+		}
+		if natsConn.localNats, err = runServer(opts); err != nil {
+			panic(err)
+		}
+		if config.NatsURL == "" {
+			natsConn.config.NatsURL = natsConn.localNats.ClientURL()
+		}
+	}
+	if natsConn.config.NatsURL == "" {
+		natsConn.config.NatsURL = nats.DefaultURL
 	}
 
-	connMutex.Lock()
-	defer connMutex.Unlock()
-	if globalConnections == nil {
-		for range make([]int, MaxConns) {
-			bus, err := natsConn.newNatsBus()
-			if err != nil {
-				panic(err)
-			}
-			if globalConnections == nil {
-				globalConnections = &ring.Ring{Value: bus}
-			} else {
-				globalConnections = globalConnections.Link(&ring.Ring{Value: bus}).Next()
-			}
-
+	pool, err := newConnPool(config.Connections, natsConn.newNatsBus)
+	if err != nil {
+		if natsConn.localNats != nil {
+			natsConn.localNats.Shutdown()
 		}
+		panic(err)
 	}
-
-	return broker.NewSpecific(natsConn)
+	natsConn.pool = pool
+	return broker.NewSpecific(natsConn, config.ProviderOptions), natsConn.config.NatsURL
 }
 
 type Provider struct {
 	config    Config
 	debug     bool
 	localNats *server.Server
+	pool      *connPool
+	m         sync.Mutex
+	streams   map[string]bool
+	streamsM  sync.Mutex
+	lastError string
+	statusM   sync.Mutex
+
+	// closing is set by Close, closedOnce reports the Provider closed once
+	closing    int32
+	closedOnce sync.Once
 }
 
 func (s *Provider) SubscribeRaw(sub broker.Subscription, group string, handler broker.ServiceHandler) (func(), broker.Error) {
-	m.Lock()
-	defer m.Unlock()
-	subj, msgs := s.subscribe(sub, handler)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Durable events are monitored through a JetStream consumer shared by the group
+	if sub.IsDurable(group) {
+		return s.subscribeDurable(sub, group, func(sub broker.Subscription, payload []byte, _ int) (broker.AckKind,
+			time.Duration) {
+			handler(sub, payload, "")
+			return broker.AckDone, 0
+		})
+	}
+
+	subj, msgs, dispatched := s.subscribe(sub, handler)
 	reg, _ := regexp.Compile("\\$[^.]+")
 	subj = reg.ReplaceAllString(subj, "*")
 
+	// Without a queue group every subscription receives the messages, so only one connection is used. Subscriptions
+	// are flushed as RequestAll and monitors expect to receive the messages published once SubscribeRaw returns
+	conns := s.config.Connections
+	if group == "" {
+		conns = 1
+	}
+
 	unsubs := make([]func(), 0)
-	for i := 0; i < MaxConns; i++ {
+	for i := 0; i < conns; i++ {
 		bus := s.requestConn()
 		unsub, err := bus.ChanQueueSubscribe(subj, group, msgs)
 		if err != nil {
 			log.Printf("nats: failed to subscribe to %s: %v", subj, err)
 		}
 		unsubs = append(unsubs, func() { unsub.Unsubscribe() })
+		if err = bus.Flush(); err != nil {
+			log.Printf("nats: failed to flush subscription to %s: %v", subj, err)
+		}
 		s.releaseConn(bus)
 	}
 
@@ -108,22 +160,16 @@ func (s *Provider) SubscribeRaw(sub broker.Subscription, group string, handler b
 			unsub()
 		}
 		close(msgs)
+		<-dispatched
 	}, nil
 }
 
 func (s *Provider) Close() {
+	s.notifyClosed()
 	s.drain()
 	s.printDebug("nats: closing bus")
 	defer s.printDebug("nats: closing bus done")
-	connMutex.Lock()
-	connMutex.Unlock()
-	globalConnections.Do(func(busI interface{}) {
-		if bus, ok := busI.(*nats.Conn); ok {
-			bus.Close()
-		}
-	})
-	globalConnections.Unlink(globalConnections.Len() - 1)
-	globalConnections = nil
+	s.pool.close()
 	if s.localNats != nil {
 		s.localNats.Shutdown()
 	}
@@ -131,8 +177,14 @@ func (s *Provider) Close() {
 
 func (s *Provider) PublishRaw(subject string, data []byte) broker.Error {
 	s.printDebug("nats: publishing endpoint %s", subject)
-	bus := s.requestConn()
-	defer s.releaseConn(bus)
+	var bus *nats.Conn
+	if strings.HasPrefix(subject, nats.InboxPrefix) {
+		// Replies to the same inbox, like control frames before the reply, keep their order on one connection
+		bus = s.pool.pinned(subject)
+	} else {
+		bus = s.requestConn()
+		defer s.releaseConn(bus)
+	}
 	err := bus.Publish(subject, data)
 	if err != nil {
 		return broker.InternalError(err)
@@ -140,19 +192,48 @@ func (s *Provider) PublishRaw(subject string, data []byte) broker.Error {
 	return nil
 }
 
-func (s *Provider) RequestRaw(subject string, data []byte, overrideTimeout ...time.Duration) ([]byte, broker.Error) {
+func (s *Provider) RequestRaw(ctx context.Context, subject string, data []byte,
+	overrideTimeout ...time.Duration) ([]byte, broker.Error) {
+	var rs []byte
+	rErr := s.request(ctx, subject, data, func(msg []byte) bool {
+		rs = msg
+		return false
+	}, overrideTimeout...)
+	if rErr != nil {
+		return nil, rErr
+	}
+	return rs, nil
+}
+
+func (s *Provider) RequestStreamRaw(ctx context.Context, subject string, data []byte,
+	handler func([]byte) bool) broker.Error {
+	return s.request(ctx, subject, data, handler)
+}
+
+func (s *Provider) RequestAllRaw(ctx context.Context, subject string, data []byte,
+	handler func([]byte) bool) broker.Error {
+	return s.request(ctx, subject, data, handler)
+}
+
+// request publishes data on subject and passes the responses to handler until it returns false
+func (s *Provider) request(ctx context.Context, subject string, data []byte, handler func([]byte) bool,
+	overrideTimeout ...time.Duration) broker.Error {
 	s.printDebug("nats: requesting endpoint %s", subject)
+	if ctxErr := broker.ErrorFromContext(ctx); ctxErr != nil {
+		return ctxErr
+	}
+
 	bus := s.requestConn()
 	defer s.releaseConn(bus)
 
-	c := make(chan *nats.Msg, 50)
+	c := make(chan *nats.Msg, s.config.InboxSize)
 	defer close(c)
 
 	inbox := nats.NewInbox()
 	sr, err := bus.ChanSubscribe(inbox, c)
 	if err != nil {
 		s.printDebug("nats: request to endpoint %s inbox %s failed with internal error %s", subject, inbox, err)
-		return nil, broker.InternalError(err)
+		return broker.InternalError(err)
 	}
 
 	defer func() {
@@ -169,42 +250,46 @@ func (s *Provider) RequestRaw(subject string, data []byte, overrideTimeout ...ti
 	})
 	if err != nil {
 		s.printDebug("nats: failed to publish on endpoint %s inbox %s: %s", subject, inbox, err)
-		return nil, broker.InternalError(err)
+		return broker.InternalError(err)
 	}
 
 	var t time.Duration
 	if len(overrideTimeout) > 0 {
 		t = overrideTimeout[0]
 	} else {
-		t = globalTimeout
+		t = s.config.Timeout
 	}
-	rs, rsErr := s.processResponse(subject, inbox, c, t)
-	if rsErr != nil {
-		s.printDebug("nats: failed response on endpoint %s inbox %s", subject, inbox)
-		return nil, rsErr
-	} else {
-		s.printDebug("nats: received response on endpoint %s inbox %s", subject, inbox)
+	for {
+		if deadline, ok := ctx.Deadline(); ok {
+			t = time.Until(deadline)
+		}
+		rs, rsErr := s.processResponse(ctx, subject, inbox, c, t)
+		if dropped, _ := sr.Dropped(); dropped > 0 {
+			// Responses were lost because the caller did not keep up, the following ones are incomplete
+			s.printDebug("nats: dropped %d responses on endpoint %s inbox %s", dropped, subject, inbox)
+			return broker.ErrorStreamOverflow
+		}
+		if rsErr != nil {
+			s.printDebug("nats: failed response on endpoint %s inbox %s", subject, inbox)
+			return rsErr
+		} else {
+			s.printDebug("nats: received response on endpoint %s inbox %s", subject, inbox)
+		}
+
+		if !handler(rs) {
+			return nil
+		}
 	}
+}
 
-	return rs, nil
+func (s *Provider) Timeout() time.Duration {
+	return s.config.Timeout
 }
 
 func (s *Provider) NewCall(p rids.Pattern, payload interface{}) broker.Call {
 	return broker.NewCall(p, payload)
 }
 
-func (s *Provider) connError(_ *nats.Conn, err error) {
-	if err != nil {
-		s.printDebug("nats: disconnected with error: %s", err)
-	}
-}
-
-func (s *Provider) asyncError(_ *nats.Conn, sub *nats.Subscription, err error) {
-	if err != nil {
-		log.Printf("nats: async error: %v", err)
-	}
-}
-
 func (s *Provider) newNatsBus() (*nats.Conn, error) {
 	opts := nats.GetDefaultOptions()
 	opts.Url = s.config.NatsURL
@@ -212,10 +297,18 @@ func (s *Provider) newNatsBus() (*nats.Conn, error) {
 	opts.MaxReconnect = -1
 	opts.PingInterval = 5 * time.Second
 	opts.MaxPingsOut = 3
-	opts.Timeout = globalTimeout
-	opts.DisconnectedErrCB = s.connError
-	opts.AsyncErrorCB = s.asyncError
+	opts.Timeout = s.config.Timeout
+	s.lifecycleOptions(&opts)
 	opts.FlusherTimeout = 3 * time.Second
+	options, err := s.config.connectOptions()
+	if err != nil {
+		return nil, err
+	}
+	for _, option := range options {
+		if err = option(&opts); err != nil {
+			return nil, err
+		}
+	}
 	bus, err := opts.Connect()
 	if err != nil {
 		return nil, err
@@ -224,26 +317,17 @@ func (s *Provider) newNatsBus() (*nats.Conn, error) {
 }
 
 func (s *Provider) drain() {
-	s.printDebug("nats: closing bus")
-	defer s.printDebug("nats: closing bus done")
-	connMutex.Lock()
-	defer connMutex.Unlock()
-	globalConnections.Do(func(busI interface{}) {
-		if bus, ok := busI.(*nats.Conn); ok {
-			bus.Drain()
-		}
-	})
+	s.printDebug("nats: draining bus")
+	defer s.printDebug("nats: draining bus done")
+	s.pool.drain()
 }
 
 func (s *Provider) requestConn() *nats.Conn {
-	connMutex.Lock()
-	defer connMutex.Unlock()
-	next := globalConnections.Next()
-	globalConnections = next
-	return next.Value.(*nats.Conn)
+	return s.pool.acquire()
 }
 
-func (s *Provider) releaseConn(_ *nats.Conn) {
+func (s *Provider) releaseConn(bus *nats.Conn) {
+	s.pool.release(bus)
 }
 
 func (s *Provider) printDebug(str string, params ...interface{}) {
@@ -252,33 +336,41 @@ func (s *Provider) printDebug(str string, params ...interface{}) {
 	}
 }
 
-func (s *Provider) subscribe(sub broker.Subscription, handler broker.ServiceHandler) (string, chan *nats.Msg) {
-	msgs := make(chan *nats.Msg, MaxChans)
+// subscribe dispatches the messages sent to the returned channel to handler. Once the channel is closed, the
+// dispatched channel is closed after the messages it held are handed to the WorkerPool
+func (s *Provider) subscribe(sub broker.Subscription, handler broker.ServiceHandler) (string, chan *nats.Msg,
+	<-chan struct{}) {
+	msgs := make(chan *nats.Msg, s.config.ChannelSize)
+	dispatched := make(chan struct{})
 
 	go func() {
-		h := handler
 		p := sub.Resource
-		for msgCopy := range msgs {
-			msg := msgCopy
-			go func() {
-				if msg == nil {
-					panic("nats: invalid message")
-				}
-				h(sub, msg.Data, msg.Reply)
-			}()
+		pool := broker.NewWorkerPool(sub, handler, func(replyEndpoint string) {
+			if err := s.PublishRaw(replyEndpoint, broker.ErrorServiceUnavailable.ToJSON()); err != nil {
+				log.Printf("nats: failed to reject message on endpoint %s: %v", p.EndpointNameSpecific(), err)
+			}
+		})
+		defer pool.Close()
+		for msg := range msgs {
+			if msg == nil {
+				panic("nats: invalid message")
+			}
+			pool.Dispatch(msg.Data, msg.Reply)
 		}
+		close(dispatched)
 		s.printDebug("nats: channel closed on endpoint %s", p.EndpointNameSpecific())
 	}()
 
 	s.printDebug("nats: subscribed on %s\n", sub.Resource.EndpointNameSpecific())
-	return sub.Resource.EndpointNameSpecific(), msgs
+	return sub.Resource.EndpointNameSpecific(), msgs, dispatched
 }
 
-func (s *Provider) processResponse(subject, inbox string, c chan *nats.Msg, t time.Duration) (json.RawMessage, broker.Error) {
+func (s *Provider) processResponse(ctx context.Context, subject, inbox string, c chan *nats.Msg,
+	t time.Duration) (json.RawMessage, broker.Error) {
 	s.printDebug("nats: waiting for response on endpoint %s inbox %s", subject, inbox)
 	start := time.Now()
 	defer func() {
-		s.printDebug("nats: finished processing response on endpoint %s inbox %s in %f ms",
+		s.printDebug("nats: finished processing response on endpoint %s inbox %s in %d ms",
 			subject, inbox, time.Now().Sub(start).Milliseconds())
 	}()
 	for {
@@ -286,25 +378,25 @@ func (s *Provider) processResponse(subject, inbox string, c chan *nats.Msg, t ti
 		select {
 		case msg := <-c:
 			timer.Stop()
-			respStr := string(msg.Data)
-			timeout, resErr := s.getTimeout(respStr)
+			ctl, resErr := broker.DecodeControl(msg.Data)
 			if resErr != nil {
-				s.printDebug("nats: invalid timeout response on endpoint %s inbox %s: %s", subject, inbox, resErr.Error())
+				s.printDebug("nats: invalid control response on endpoint %s inbox %s: %s", subject, inbox, resErr.Error())
 				return nil, resErr
 			}
 
-			if timeout != nil {
-				t = *timeout
-				s.printDebug("nats: timeout extended in %f seconds on endpoint %s inbox %s", t.Seconds(), subject, inbox)
+			if ctl != nil {
+				if ctl.Kind == broker.ControlExtend {
+					t = ctl.Timeout
+					s.printDebug("nats: timeout extended in %f seconds on endpoint %s inbox %s", t.Seconds(), subject,
+						inbox)
+				} else {
+					broker.NotifyProgress(ctx, ctl.Data)
+				}
 				break
 			}
 
-			if respStr == "" {
-				// TODO: Map all possible problematic headers
-				//if msg.Header.Get("Status") == "503" {
-				//	return nil, broker.ErrorServiceUnavailable
-				//}
-				return nil, broker.ErrorServiceUnavailable
+			if len(msg.Data) == 0 {
+				return nil, emptyReplyError(msg)
 			}
 			return msg.Data, nil
 
@@ -314,32 +406,30 @@ func (s *Provider) processResponse(subject, inbox string, c chan *nats.Msg, t ti
 					subject, inbox, time.Now().Sub(start).Seconds())
 			}
 			return nil, broker.ErrorTimeout
+
+		case <-ctx.Done():
+			timer.Stop()
+			s.printDebug("nats: context done on endpoint %s inbox %s in %f: %s",
+				subject, inbox, time.Now().Sub(start).Seconds(), ctx.Err())
+			return nil, broker.ErrorFromContext(ctx)
 		}
 	}
 }
 
-// getTimeout is the function that returns the timeout for a Broker request
-func (s *Provider) getTimeout(respStr string) (*time.Duration, broker.Error) {
-	if strings.HasPrefix(respStr, "timeout:") {
-		respParts := strings.Split(respStr, ":")
-		if len(respParts) == 2 && respParts[0] == "timeout" {
-			if len(respParts[1]) == 0 {
-				err := broker.NewInvalidParamsError(fmt.Sprintf("Invalid timeout param %s", respParts[1]))
-				return nil, err
-			}
-
-			if len(respParts[1]) > 20 {
-				log.Printf("nats: timeout: too large timeout message: %s", respParts[1])
-			}
-
-			timeout, err := strconv.ParseInt(respParts[1], 10, 64)
-			if err != nil {
-				return nil, broker.InternalError(err)
-			}
-			t := time.Duration(timeout)
-			return &t, nil
-		}
+// emptyReplyError maps the status of the empty messages sent by the server, like when there are no responders
+func emptyReplyError(msg *nats.Msg) broker.Error {
+	status := msg.Header.Get("Status")
+	switch status {
+	case "503":
+		return broker.ErrorServiceUnavailable
+	case "408":
+		return broker.ErrorTimeout
+	case "":
+		return broker.InternalError(fmt.Errorf("nats: empty reply on %s", msg.Subject))
 	}
-
-	return nil, nil
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return broker.InternalError(fmt.Errorf("nats: invalid status %s on %s", status, msg.Subject))
+	}
+	return broker.NewError(msg.Header.Get("Description"), code, nil)
 }
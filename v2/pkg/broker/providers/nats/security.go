@@ -0,0 +1,85 @@
+package nats
+
+import (
+	"fmt"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// TLSConfig configures TLS with PEM encoded files
+type TLSConfig struct {
+	// CAFile verifies the certificates presented by the other side. Clients use the system roots when it is empty and
+	// the local server requires client certificates when it is set
+	CAFile string
+
+	// CertFile and KeyFile are the certificate presented to the other side, required by the local server
+	CertFile string
+	KeyFile  string
+}
+
+// LocalAuthorization is the authorization block of the local server
+type LocalAuthorization struct {
+	// Users maps the names of the users allowed to connect to their passwords
+	Users map[string]string
+
+	// NKeys lists the public NKeys of the users allowed to connect
+	NKeys []string
+}
+
+// connectOptions returns the TLS and authentication options of the connections
+func (c Config) connectOptions() ([]nats.Option, error) {
+	var options []nats.Option
+	if c.TLS != nil {
+		options = append(options, nats.Secure())
+		if c.TLS.CAFile != "" {
+			options = append(options, nats.RootCAs(c.TLS.CAFile))
+		}
+		if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+			options = append(options, nats.ClientCert(c.TLS.CertFile, c.TLS.KeyFile))
+		}
+	}
+
+	if c.NKeySeedFile != "" {
+		option, err := nats.NkeyOptionFromSeed(c.NKeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("nats: invalid nkey seed: %w", err)
+		}
+		options = append(options, option)
+	}
+	if c.CredsFile != "" {
+		options = append(options, nats.UserCredentials(c.CredsFile))
+	}
+	if c.User != "" {
+		options = append(options, nats.UserInfo(c.User, c.Password))
+	}
+	return options, nil
+}
+
+// secure sets the TLS and authorization of the local server options
+func (c ServerConfig) secure(opts *server.Options) error {
+	if c.TLS != nil {
+		tlsConfig, err := server.GenTLSConfig(&server.TLSConfigOpts{
+			CertFile: c.TLS.CertFile,
+			KeyFile:  c.TLS.KeyFile,
+			CaFile:   c.TLS.CAFile,
+			Verify:   c.TLS.CAFile != "",
+		})
+		if err != nil {
+			return fmt.Errorf("nats: invalid local server tls: %w", err)
+		}
+		opts.TLSConfig = tlsConfig
+		opts.TLSVerify = c.TLS.CAFile != ""
+		opts.TLSTimeout = 2
+	}
+
+	if auth := c.Authorization; auth != nil {
+		for user, password := range auth.Users {
+			opts.Users = append(opts.Users, &server.User{Username: user, Password: password})
+		}
+		for _, nkey := range auth.NKeys {
+			opts.Nkeys = append(opts.Nkeys, &server.NkeyUser{Nkey: nkey})
+		}
+	}
+	return nil
+}
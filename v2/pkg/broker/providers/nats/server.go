@@ -1,25 +1,145 @@
 package nats
 
 import (
+	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/nats-io/nats-server/v2/server"
 )
 
-var defaultNatsOptions = server.Options{
-	Host:       "127.0.0.1",
-	Port:       4222,
-	MaxPayload: 100 * 1024 * 1024,
-	MaxPending: 100 * 1024 * 1024,
+// RandomPort makes the local server listen on a free port chosen by the system
+const RandomPort = server.RANDOM_PORT
+
+// ServerConfig configures the local server started when Config.LocalNats is set
+type ServerConfig struct {
+	// Name identifies the server on the cluster, required by JetStream on clusters
+	Name string
+
+	// Host and Port are where clients connect, 127.0.0.1 and 4222 when empty. Use RandomPort for a free port
+	Host string
+	Port int
+
+	// MaxPayload limits the size of the messages, 100MB when zero
+	MaxPayload int32
+
+	// MonitorPort serves the HTTP monitoring endpoints on Host when set. Use RandomPort for a free port
+	MonitorPort int
+
+	// Cluster joins the server to other servers when set
+	Cluster *ClusterConfig
+
+	// LeafNodeRemotes are the URLs of the servers this one connects to as a leaf node
+	LeafNodeRemotes []string
+
+	// JetStream enables JetStream, required to publish and monitor durable events
+	JetStream bool
+
+	// StoreDir is where JetStream keeps the durable events, a temporary directory when empty
+	StoreDir string
+
+	// TLS enables TLS for the clients
+	TLS *TLSConfig
+
+	// Authorization restricts the users allowed to connect to the global account
+	Authorization *LocalAuthorization
+
+	// Accounts isolates the subjects of the users of each account
+	Accounts []AccountConfig
+}
+
+// ClusterConfig joins the local server to a cluster
+type ClusterConfig struct {
+	// Name is shared by every server of the cluster
+	Name string
+
+	// Host and Port are where the other servers connect, Host defaults to the ServerConfig one. Use RandomPort for a
+	// free port
+	Host string
+	Port int
+
+	// Routes are the URLs of the other servers, like nats://127.0.0.1:6222
+	Routes []string
+}
+
+// AccountConfig is an account of the local server with its users
+type AccountConfig struct {
+	Name string
+
+	// Users maps the names of the users of the account to their passwords
+	Users map[string]string
 }
 
-func runServer(opts *server.Options) *server.Server {
-	if opts == nil {
-		opts = &defaultNatsOptions
+// options builds the local server options from c
+func (c ServerConfig) options(debug, trace bool) (*server.Options, error) {
+	opts := &server.Options{
+		ServerName: c.Name,
+		Host:       c.Host,
+		Port:       c.Port,
+		MaxPayload: c.MaxPayload,
+		MaxPending: 100 * 1024 * 1024,
+		Debug:      debug,
+		Trace:      trace,
+		JetStream:  c.JetStream,
+		StoreDir:   c.StoreDir,
+	}
+	if opts.Host == "" {
+		opts.Host = "127.0.0.1"
+	}
+	if opts.Port == 0 {
+		opts.Port = 4222
+	}
+	if opts.MaxPayload == 0 {
+		opts.MaxPayload = 100 * 1024 * 1024
+	}
+	if c.MonitorPort != 0 {
+		opts.HTTPHost = opts.Host
+		opts.HTTPPort = c.MonitorPort
+	}
+
+	if c.Cluster != nil {
+		opts.Cluster.Name = c.Cluster.Name
+		opts.Cluster.Host = c.Cluster.Host
+		if opts.Cluster.Host == "" {
+			opts.Cluster.Host = opts.Host
+		}
+		opts.Cluster.Port = c.Cluster.Port
+		if len(c.Cluster.Routes) > 0 {
+			opts.Routes = server.RoutesFromStr(strings.Join(c.Cluster.Routes, ","))
+		}
 	}
+
+	if len(c.LeafNodeRemotes) > 0 {
+		remote := &server.RemoteLeafOpts{}
+		for _, remoteURL := range c.LeafNodeRemotes {
+			parsed, err := url.Parse(remoteURL)
+			if err != nil {
+				return nil, fmt.Errorf("nats: invalid leaf node remote %s: %w", remoteURL, err)
+			}
+			remote.URLs = append(remote.URLs, parsed)
+		}
+		opts.LeafNode.Remotes = []*server.RemoteLeafOpts{remote}
+	}
+
+	for _, accountConfig := range c.Accounts {
+		account := server.NewAccount(accountConfig.Name)
+		opts.Accounts = append(opts.Accounts, account)
+		for user, password := range accountConfig.Users {
+			opts.Users = append(opts.Users, &server.User{Username: user, Password: password, Account: account})
+		}
+	}
+
+	if err := c.secure(opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+func runServer(opts *server.Options) (*server.Server, error) {
 	s, err := server.NewServer(opts)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	s.ConfigureLogger()
@@ -29,7 +149,8 @@ func runServer(opts *server.Options) *server.Server {
 
 	// Wait for accept loop(s) to be started
 	if !s.ReadyForConnections(10 * time.Second) {
-		panic("Unable to start Broker Server in Go Routine")
+		s.Shutdown()
+		return nil, fmt.Errorf("unable to start Broker Server in Go Routine")
 	}
-	return s
+	return s, nil
 }
@@ -1,6 +1,7 @@
 package testProvider
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"time"
@@ -16,18 +17,21 @@ type callRequest struct {
 	Pattern rids.Pattern `json:"pattern"`
 	error   broker.Error
 	result  broker.Message
-	Token   broker.RawData `json:"token"`
-	Payload broker.RawData `json:"payload"`
+	Token   broker.RawData    `json:"token"`
+	Payload broker.RawData    `json:"payload"`
+	Header  map[string]string `json:"headers,omitempty"`
 	okF     func(...interface{})
 	errF    func(interface{})
 	fileF   func(*dataurl.DataURL)
+	ctx     context.Context
 }
 
 func (c *callRequest) UnmarshalJSON(data []byte) error {
 	type callInnerType struct {
-		Pattern json.RawMessage `json:"pattern"`
-		Token   broker.RawData  `json:"token"`
-		Payload broker.RawData  `json:"payload"`
+		Pattern json.RawMessage   `json:"pattern"`
+		Token   broker.RawData    `json:"token"`
+		Payload broker.RawData    `json:"payload"`
+		Header  map[string]string `json:"headers"`
 	}
 	var callInner callInnerType
 	err := json.Unmarshal(data, &callInner)
@@ -42,6 +46,7 @@ func (c *callRequest) UnmarshalJSON(data []byte) error {
 	c.Payload = callInner.Payload
 	c.Pattern = pattern
 	c.Token = callInner.Token
+	c.Header = callInner.Header
 	return nil
 }
 
@@ -109,6 +114,10 @@ func (c *callRequest) Timeout(_ time.Duration) {
 	// Do nothing on Timeout call
 }
 
+func (c *callRequest) Progress(_ interface{}) {
+	// Do nothing on Progress call
+}
+
 func (c *callRequest) File(f *dataurl.DataURL) {
 	c.error = nil
 	if c.fileF != nil {
@@ -151,6 +160,11 @@ func (c *callRequest) OK(result ...interface{}) {
 	c.result = success
 }
 
+// Stream buffers the chunks, mocks always receive them at once through OK
+func (c *callRequest) Stream() broker.Stream {
+	return broker.NewBufferedStream(c)
+}
+
 func (c *callRequest) InternalError(err error) {
 	c.error = broker.InternalError(err)
 	if c.errF != nil {
@@ -190,6 +204,27 @@ func (c *callRequest) SetProvider(provider broker.Provider) {
 	panic("implement me")
 }
 
+func (c *callRequest) Headers() map[string]string {
+	return c.Header
+}
+
+func (c *callRequest) SetHeaders(headers map[string]string) {
+	c.Header = headers
+}
+
+func (c *callRequest) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+func (c *callRequest) SetContext(ctx context.Context) context.CancelFunc {
+	var cancel context.CancelFunc
+	c.ctx, cancel = context.WithCancel(ctx)
+	return cancel
+}
+
 func (c *callRequest) SetEndpoint(p rids.Pattern) {
 	//TODO implement me
 	panic("implement me")
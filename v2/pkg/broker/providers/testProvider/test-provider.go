@@ -18,8 +18,11 @@ type Subscription struct {
 }
 
 type Mocks struct {
-	Requests     map[string]RequestMock
-	Publishes    map[string]RequestMock
+	Requests  map[string]RequestMock
+	Publishes map[string]RequestMock
+
+	// Broadcasts has a RequestMock per instance answering RequestAll on the endpoint
+	Broadcasts   map[string][]RequestMock
 	ExternalAPIs map[string]interface{}
 	Repository   interface{}
 }
@@ -49,6 +52,10 @@ func (t *testProvider) SetResponseHandlers(handlers ResponseHandlers) {
 	t.respHandlers = handlers
 }
 
+func (t *testProvider) Drain(ctx context.Context) int {
+	return t.base.Drain(ctx)
+}
+
 func (t *testProvider) Subscribe(s broker.Subscription, handler broker.ServiceHandler) (func(), broker.Error) {
 	return t.base.Subscribe(s, handler)
 }
@@ -62,18 +69,62 @@ func (t *testProvider) Get(p rids.Pattern, rs interface{}, token ...[]byte) brok
 	return t.base.Get(p, rs, token...)
 }
 
+func (t *testProvider) GetCtx(ctx context.Context, p rids.Pattern, rs interface{}, token ...[]byte) broker.Error {
+	return t.base.GetCtx(ctx, p, rs, token...)
+}
+
 func (t *testProvider) Request(p rids.Pattern, payload interface{}, rs interface{}, token ...[]byte) broker.Error {
 	return t.base.Request(p, payload, rs, token...)
 }
 
+func (t *testProvider) RequestCtx(ctx context.Context, p rids.Pattern, payload interface{}, rs interface{},
+	token ...[]byte) broker.Error {
+	return t.base.RequestCtx(ctx, p, payload, rs, token...)
+}
+
+func (t *testProvider) RequestAsync(ctx context.Context, p rids.Pattern, payload interface{}, rs interface{},
+	token ...[]byte) broker.Future {
+	return t.base.RequestAsync(ctx, p, payload, rs, token...)
+}
+
+func (t *testProvider) RequestStream(ctx context.Context, p rids.Pattern, payload interface{},
+	token ...[]byte) (<-chan broker.StreamChunk, broker.Error) {
+	return t.base.RequestStream(ctx, p, payload, token...)
+}
+
+func (t *testProvider) RequestAll(ctx context.Context, p rids.Pattern, payload interface{},
+	opts broker.RequestAllOptions, token ...[]byte) ([]broker.InstanceReply, broker.Error) {
+	return t.base.RequestAll(ctx, p, payload, opts, token...)
+}
+
 func (t *testProvider) Publish(p rids.Pattern, payload interface{}, token ...[]byte) broker.Error {
 	return t.base.Publish(p, payload, token...)
 }
 
+func (t *testProvider) PublishCtx(ctx context.Context, p rids.Pattern, payload interface{}, token ...[]byte) broker.Error {
+	return t.base.PublishCtx(ctx, p, payload, token...)
+}
+
 func (t *testProvider) Reply(replyEndpoint string, payload []byte) broker.Error {
 	return t.base.Reply(replyEndpoint, payload)
 }
 
+func (t *testProvider) DeadLetters() []broker.DeadLetter {
+	return t.base.DeadLetters()
+}
+
+func (t *testProvider) DeadLetter(id string) (broker.DeadLetter, broker.Error) {
+	return t.base.DeadLetter(id)
+}
+
+func (t *testProvider) ReplayDeadLetter(id string) broker.Error {
+	return t.base.ReplayDeadLetter(id)
+}
+
+func (t *testProvider) Status() broker.Status {
+	return t.base.Status()
+}
+
 func (t *testProvider) SetMocks(mocks Mocks) {
 	t.mocks = mocks
 }
@@ -81,8 +132,8 @@ func (t *testProvider) SetMocks(mocks Mocks) {
 var testProviderImpl *testProvider
 
 // NewTestProvider builds the default test infrastructure provider. It implements the broker.Provider interface and
-// some helpers to allow unit testing broker.Subscribe
-func NewTestProvider(ctx context.Context) Provider {
+// some helpers to allow unit testing broker.Subscribe. When options are given the provider interceptors are replaced
+func NewTestProvider(ctx context.Context, options ...broker.ProviderOptions) Provider {
 	if testProviderImpl == nil {
 		testProviderImpl = &testProvider{
 			ctx: ctx,
@@ -93,8 +144,10 @@ func NewTestProvider(ctx context.Context) Provider {
 			subscriptions: make(map[string]Subscription),
 			monitors:      make(map[string]map[string]Subscription),
 		}
-		base := broker.NewSpecific(testProviderImpl)
+		base := broker.NewSpecific(testProviderImpl, options...)
 		testProviderImpl.base = base
+	} else if len(options) > 0 {
+		testProviderImpl.base = broker.NewSpecific(testProviderImpl, options...)
 	}
 	return testProviderImpl
 }
@@ -129,10 +182,52 @@ func (t *testProvider) requestMocked(
 	return nil, broker.ErrorServiceUnavailable
 }
 
-func (t *testProvider) RequestRaw(_ string, data []byte, overrideTimeout ...time.Duration) ([]byte, broker.Error) {
+func (t *testProvider) RequestRaw(ctx context.Context, _ string, data []byte,
+	overrideTimeout ...time.Duration) ([]byte, broker.Error) {
+	if err := broker.ErrorFromContext(ctx); err != nil {
+		return nil, err
+	}
 	return t.requestMocked(t.mocks.Requests, data, overrideTimeout...)
 }
 
+func (t *testProvider) RequestStreamRaw(ctx context.Context, _ string, data []byte, handler func([]byte) bool) broker.Error {
+	if err := broker.ErrorFromContext(ctx); err != nil {
+		return err
+	}
+	result, rErr := t.requestMocked(t.mocks.Requests, data)
+	if rErr != nil {
+		return rErr
+	}
+	handler(result)
+	return nil
+}
+
+func (t *testProvider) RequestAllRaw(ctx context.Context, _ string, data []byte, handler func([]byte) bool) broker.Error {
+	if err := broker.ErrorFromContext(ctx); err != nil {
+		return err
+	}
+
+	var c callRequest
+	if err := json.Unmarshal(data, &c); err != nil {
+		return broker.InternalError(err)
+	}
+	instances := t.mocks.Broadcasts[c.Endpoint().EndpointName()]
+	if len(instances) == 0 {
+		return broker.ErrorServiceUnavailable
+	}
+
+	for _, instance := range instances {
+		result, rErr := t.requestMocked(map[string]RequestMock{c.Endpoint().EndpointName(): instance}, data)
+		if rErr != nil {
+			result = rErr.ToJSON()
+		}
+		if !handler(result) {
+			break
+		}
+	}
+	return nil
+}
+
 func (t *testProvider) PublishRaw(_ string, data []byte) broker.Error {
 	_, err := t.requestMocked(t.mocks.Publishes, data)
 	return err
@@ -148,6 +243,10 @@ func (t *testProvider) SubscribeRaw(s broker.Subscription, _ string, handler bro
 
 func (t *testProvider) Close() {}
 
+func (t *testProvider) Timeout() time.Duration {
+	return 0
+}
+
 func (t *testProvider) NewCall(p rids.Pattern, payload interface{}) broker.Call {
 	return NewCall(p, payload, nil, t.respHandlers.OkF, t.respHandlers.ErrF, t.respHandlers.FileF)
 }
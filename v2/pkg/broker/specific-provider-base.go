@@ -1,25 +1,72 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"math"
 	"net/http"
 	"reflect"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/spike-events/spike-broker/v2/pkg/rids"
+	"github.com/spike-events/spike-broker/v2/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func NewSpecific(impl SpecificProvider) Provider {
-	return &specificProviderBase{
+// NewSpecific builds a Provider on top of a SpecificProvider implementation
+func NewSpecific(impl SpecificProvider, options ...ProviderOptions) Provider {
+	s := &specificProviderBase{
 		impl: impl,
 	}
+	if len(options) > 0 {
+		s.opts = options[0]
+	}
+	return s
+}
+
+// timeoutBudgetSetter is implemented by calls able to send the caller's remaining time to the handler
+type timeoutBudgetSetter interface {
+	setTimeoutBudget(budget time.Duration)
 }
 
 type specificProviderBase struct {
-	impl SpecificProvider
-	m    sync.Mutex
+	impl      SpecificProvider
+	opts      ProviderOptions
+	m         sync.Mutex
+	breakers  map[string]*circuitBreaker
+	breakersM sync.Mutex
+	accepted  sync.Map
+	framed    sync.Map
+	acks      sync.Map
+	ackSeq    uint64
+	monitors  sync.Map
+	dead      []DeadLetter
+	deadM     sync.Mutex
+	inFlight  Tracker
+	subs      map[uint64]func()
+	subsSeq   uint64
+	subsM     sync.Mutex
+}
+
+// invoke runs the Provider interceptors around final
+func (s *specificProviderBase) invoke(ctx context.Context, kind InvocationKind, p rids.Pattern, payload interface{},
+	token [][]byte, final Invoker) Error {
+	inv := &Invocation{
+		Kind:    kind,
+		Pattern: p,
+		Payload: payload,
+	}
+	if len(token) > 0 {
+		inv.Token = token[0]
+	}
+	if kind != InvocationSubscribe && kind != InvocationMonitor {
+		defer s.track(ctx)()
+	}
+	return chainInterceptors(s.opts.Interceptors, final)(ctx, inv)
 }
 
 func (s *specificProviderBase) Close() {
@@ -27,13 +74,55 @@ func (s *specificProviderBase) Close() {
 }
 
 func (s *specificProviderBase) Subscribe(sub Subscription, handler ServiceHandler) (func(), Error) {
-	s.m.Lock()
-	defer s.m.Unlock()
-	return s.impl.SubscribeRaw(sub, sub.Resource.Service(), handler)
+	var unsubscribe func()
+	rErr := s.invoke(context.Background(), InvocationSubscribe, sub.Resource, nil, nil,
+		func(ctx context.Context, inv *Invocation) Error {
+			s.m.Lock()
+			defer s.m.Unlock()
+			var rErr Error
+			sub.Resource = inv.Pattern
+			sub.inFlight = &s.inFlight
+			unsubscribe, rErr = s.impl.SubscribeRaw(sub, sub.Resource.Service(), handler)
+			if rErr != nil || !sub.Broadcast {
+				return rErr
+			}
+
+			unsubscribeBroadcast, rErr := s.subscribeBroadcast(sub, handler)
+			if rErr != nil {
+				unsubscribe()
+				return rErr
+			}
+			unsubscribeQueue := unsubscribe
+			unsubscribe = func() {
+				unsubscribeQueue()
+				unsubscribeBroadcast()
+			}
+			return nil
+		})
+	if rErr == nil && unsubscribe != nil {
+		unsubscribe = s.register(unsubscribe)
+	}
+	return unsubscribe, rErr
 }
 
 func (s *specificProviderBase) Monitor(monitoringGroup string, sub Subscription, handler ServiceHandler,
 	token ...[]byte) (func(), Error) {
+	var unsubscribe func()
+	rErr := s.invoke(context.Background(), InvocationMonitor, sub.Resource, nil, token,
+		func(ctx context.Context, inv *Invocation) Error {
+			var rErr Error
+			sub.Resource = inv.Pattern
+			unsubscribe, rErr = s.monitor(ctx, monitoringGroup, sub, handler, inv.tokens()...)
+			return rErr
+		})
+	if rErr == nil && unsubscribe != nil {
+		unsubscribe = s.register(unsubscribe)
+	}
+	return unsubscribe, rErr
+}
+
+func (s *specificProviderBase) monitor(ctx context.Context, monitoringGroup string, sub Subscription,
+	handler ServiceHandler, token ...[]byte) (func(), Error) {
 	s.m.Lock()
 	defer s.m.Unlock()
 
@@ -51,36 +140,126 @@ func (s *specificProviderBase) Monitor(monitoringGroup string, sub Subscription,
 			if err != nil {
 				return nil, InternalError(err)
 			}
-			rErr := s.Request(p, subEncoded, nil, token...)
+			rErr := s.send(ctx, p, subEncoded, nil, token...)
 			if rErr != nil && rErr.Code() != ErrorServiceUnavailable.Code() {
 				// FIXME: Ignore Service Unavailable (compatible with V1)
 				return nil, rErr
 			}
 		}
 	}
-	return s.impl.SubscribeRaw(sub, monitoringGroup, handler)
+	sub.inFlight = &s.inFlight
+	sub.group = monitoringGroup
+	policy := s.redeliveryPolicy(sub)
+	durable, ok := s.impl.(DurableSubscriber)
+	ok = ok && sub.IsDurable(monitoringGroup)
+	if policy == nil && !ok {
+		return s.impl.SubscribeRaw(sub, monitoringGroup, handler)
+	}
+
+	m := &ackMonitor{base: s, group: monitoringGroup, sub: sub, handler: handler, policy: policy}
+	var unsubscribe func()
+	var rErr Error
+	if ok {
+		unsubscribe, rErr = durable.SubscribeDurableRaw(sub, monitoringGroup, m.handleDurable)
+	} else {
+		unsubscribe, rErr = s.impl.SubscribeRaw(sub, monitoringGroup, m.handle)
+	}
+	if rErr != nil {
+		return nil, rErr
+	}
+	s.monitors.Store(m.key(), m)
+	return func() {
+		s.monitors.Delete(m.key())
+		unsubscribe()
+	}, nil
 }
 
 func (s *specificProviderBase) Get(p rids.Pattern, rs interface{}, token ...[]byte) Error {
-	return s.Request(p, nil, rs, token...)
+	return s.RequestCtx(context.Background(), p, nil, rs, token...)
+}
+
+func (s *specificProviderBase) GetCtx(ctx context.Context, p rids.Pattern, rs interface{}, token ...[]byte) Error {
+	return s.RequestCtx(ctx, p, nil, rs, token...)
 }
 
 func (s *specificProviderBase) Request(p rids.Pattern, payload interface{}, rs interface{}, token ...[]byte) Error {
-	c := s.impl.NewCall(p, payload)
-	if len(token) > 0 && len(token[0]) > 0 {
-		c.SetToken(token[0])
+	return s.RequestCtx(context.Background(), p, payload, rs, token...)
+}
+
+func (s *specificProviderBase) RequestCtx(ctx context.Context, p rids.Pattern, payload interface{}, rs interface{},
+	token ...[]byte) Error {
+	return s.invoke(ctx, InvocationRequest, p, payload, token, func(ctx context.Context, inv *Invocation) Error {
+		return s.guard(ctx, inv.Pattern, func() Error {
+			return s.sendWithRetry(ctx, inv.Pattern, inv.Payload, rs, inv.tokens()...)
+		})
+	})
+}
+
+// sendWithRetry sends the request retrying it according to the Pattern or Provider retry policy
+func (s *specificProviderBase) sendWithRetry(ctx context.Context, p rids.Pattern, payload interface{},
+	rs interface{}, token ...[]byte) Error {
+	policy := p.RetryPolicy()
+	if policy == nil {
+		policy = s.opts.Retry
+	}
+	if !policy.Applies(p.Method()) {
+		return s.send(ctx, p, payload, rs, token...)
+	}
+
+	for attempt := 1; ; attempt++ {
+		rErr := s.send(ctx, p, payload, rs, token...)
+		if rErr == nil || attempt >= policy.MaxAttempts || !policy.Retries(rErr.Code()) ||
+			ErrorFromContext(ctx) != nil {
+			return rErr
+		}
+
+		requestRetries.WithLabelValues(p.EndpointName(), strconv.Itoa(rErr.Code())).Inc()
+		backoff := time.NewTimer(policy.Backoff(attempt))
+		select {
+		case <-backoff.C:
+		case <-ctx.Done():
+			backoff.Stop()
+			return ErrorFromContext(ctx)
+		}
+	}
+}
+
+// send traces and executes a request, skipping the interceptors
+func (s *specificProviderBase) send(ctx context.Context, p rids.Pattern, payload interface{}, rs interface{},
+	token ...[]byte) Error {
+	ctx, span := tracing.Start(ctx, "request", p.EndpointName(), string(p.Method()), trace.SpanKindClient)
+	defer span.End()
+
+	rErr := s.request(ctx, p, payload, rs, token...)
+	if rErr != nil {
+		tracing.RecordError(span, rErr.Code(), rErr.Error())
+	}
+	return rErr
+}
+
+func (s *specificProviderBase) request(ctx context.Context, p rids.Pattern, payload interface{}, rs interface{},
+	token ...[]byte) Error {
+	if err := ErrorFromContext(ctx); err != nil {
+		return err
+	}
+
+	data, rErr := s.encodeRequest(ctx, p, payload, nil, token...)
+	if rErr != nil {
+		return rErr
 	}
 
 	// Check dependencies
-	result, rErr := s.impl.RequestRaw(p.EndpointName(), c.ToJSON())
+	result, rErr := s.impl.RequestRaw(ctx, p.EndpointName(), data)
 	if rErr != nil {
+		s.forget(p, rErr)
 		return rErr
 	}
 
-	bMsg := NewMessageFromJSON(result)
+	bMsg, replyCodec, framed := decodeReply(result)
 	if bMsg == nil {
 		return NewError("invalid payload", http.StatusInternalServerError, result)
 	}
+	s.learn(p, replyCodec, framed)
 
 	if math.Abs(float64(bMsg.Code()-http.StatusOK)) >= 100 {
 		return bMsg
@@ -114,7 +293,179 @@ func (s *specificProviderBase) Request(p rids.Pattern, payload interface{}, rs i
 	return nil
 }
 
+// encodeRequest builds the Call sent to p, adding headers to the ones carried by ctx, and encodes it with the codec
+// and compression negotiated with the service
+func (s *specificProviderBase) encodeRequest(ctx context.Context, p rids.Pattern, payload interface{},
+	headers map[string]string, token ...[]byte) ([]byte, Error) {
+	c := s.newRequestCall(ctx, p, payload, headers, token...)
+	codec := s.negotiate(p, c)
+	data, err := encodeCall(c, codec)
+	if err != nil {
+		return nil, InternalError(err)
+	}
+	if _, ok := s.framed.Load(p.Service()); ok {
+		data = s.compress("request", p, data)
+	}
+	return data, nil
+}
+
+// newRequestCall builds the Call sent to p with the token, the headers and the time left to answer
+func (s *specificProviderBase) newRequestCall(ctx context.Context, p rids.Pattern, payload interface{},
+	headers map[string]string, token ...[]byte) Call {
+	c := s.impl.NewCall(p, payload)
+	if len(token) > 0 && len(token[0]) > 0 {
+		c.SetToken(token[0])
+	}
+
+	if headers = tracing.Inject(ctx, mergeHeaders(HeadersFromContext(ctx), headers)); len(headers) > 0 {
+		c.SetHeaders(headers)
+	}
+
+	if budgetSetter, ok := c.(timeoutBudgetSetter); ok {
+		budget := s.timeout()
+		if deadline, hasDeadline := ctx.Deadline(); hasDeadline {
+			budget = time.Until(deadline)
+		}
+		budgetSetter.setTimeoutBudget(budget)
+	}
+	return c
+}
+
+// learn records the codec and framing the service replied with
+func (s *specificProviderBase) learn(p rids.Pattern, codec Codec, framed bool) {
+	if framed {
+		s.framed.Store(p.Service(), true)
+	}
+	if codec != nil && !isLegacyCodec(codec) {
+		s.accepted.Store(p.Service(), codec.Name())
+	}
+}
+
+// forget drops what was learned about the service when it stops answering
+func (s *specificProviderBase) forget(p rids.Pattern, rErr Error) {
+	if rErr.Code() == ErrorServiceUnavailable.Code() || rErr.Code() == ErrorTimeout.Code() {
+		// The service may have been replaced by a version not supporting the codec or compression
+		s.accepted.Delete(p.Service())
+		s.framed.Delete(p.Service())
+	}
+}
+
+func (s *specificProviderBase) RequestStream(ctx context.Context, p rids.Pattern, payload interface{},
+	token ...[]byte) (<-chan StreamChunk, Error) {
+	var chunks <-chan StreamChunk
+	rErr := s.invoke(ctx, InvocationStream, p, payload, token, func(ctx context.Context, inv *Invocation) Error {
+		var rErr Error
+		chunks, rErr = s.stream(ctx, inv.Pattern, inv.Payload, inv.tokens()...)
+		return rErr
+	})
+	return chunks, rErr
+}
+
+// stream sends a request accepting a chunked reply, waiting for the first chunk before returning
+func (s *specificProviderBase) stream(ctx context.Context, p rids.Pattern, payload interface{},
+	token ...[]byte) (<-chan StreamChunk, Error) {
+	if err := ErrorFromContext(ctx); err != nil {
+		return nil, err
+	}
+
+	// The stream is counted until its last chunk, after RequestStream returns
+	done := s.track(ctx)
+	ctx, span := tracing.Start(ctx, "stream", p.EndpointName(), string(p.Method()), trace.SpanKindClient)
+	// Without a StreamRequester only the first reply would be received, so the handler is not offered to stream
+	var headers map[string]string
+	if _, ok := s.impl.(StreamRequester); ok {
+		headers = map[string]string{HeaderAcceptStream: "true"}
+	}
+	data, rErr := s.encodeRequest(ctx, p, payload, headers, token...)
+	if rErr != nil {
+		tracing.RecordError(span, rErr.Code(), rErr.Error())
+		span.End()
+		done()
+		return nil, rErr
+	}
+
+	reader := newStreamReader(ctx)
+	go func() {
+		defer done()
+		defer span.End()
+		defer close(reader.chunks)
+		rErr := s.requestStreamRaw(ctx, p.EndpointName(), data, reader.handle)
+		if rErr != nil {
+			s.forget(p, rErr)
+			tracing.RecordError(span, rErr.Code(), rErr.Error())
+			reader.fail(rErr)
+			return
+		}
+		s.learn(p, reader.codec, reader.framed)
+		if reader.endErr != nil {
+			tracing.RecordError(span, reader.endErr.Code(), reader.endErr.Error())
+		}
+	}()
+
+	if rErr = <-reader.started; rErr != nil {
+		return nil, rErr
+	}
+	return reader.chunks, nil
+}
+
 func (s *specificProviderBase) Publish(p rids.Pattern, payload interface{}, token ...[]byte) Error {
+	return s.PublishCtx(context.Background(), p, payload, token...)
+}
+
+func (s *specificProviderBase) PublishCtx(ctx context.Context, p rids.Pattern, payload interface{},
+	token ...[]byte) Error {
+	return s.invoke(ctx, InvocationPublish, p, payload, token, func(ctx context.Context, inv *Invocation) Error {
+		return s.sendEvent(ctx, inv.Pattern, inv.Payload, inv.tokens()...)
+	})
+}
+
+// negotiate returns the codec used to send c. Until the service answers on the Provider codec, calls are sent in
+// JSON announcing the codec on the HeaderAcceptCodec header. The accepted compression and control frames are announced
+// as well
+func (s *specificProviderBase) negotiate(p rids.Pattern, c Call) Codec {
+	accept := map[string]string{HeaderAcceptControl: strconv.Itoa(ControlProtocol)}
+	if s.opts.Compression != nil {
+		accept[HeaderAcceptCompression] = s.opts.Compression.acceptHeader()
+	}
+
+	codec := JSONCodec
+	if !isLegacyCodec(s.opts.Codec) {
+		if accepted, ok := s.accepted.Load(p.Service()); ok && accepted == s.opts.Codec.Name() {
+			codec = s.opts.Codec
+		} else {
+			accept[HeaderAcceptCodec] = s.opts.Codec.Name()
+		}
+	}
+
+	c.SetHeaders(mergeHeaders(c.Headers(), accept))
+	return codec
+}
+
+// compress compresses an envelope according to the Provider options
+func (s *specificProviderBase) compress(kind string, p rids.Pattern, data []byte) []byte {
+	compressed, ratio := compressEnvelope(s.opts.Compression, kind, data)
+	if s.opts.Debug && ratio > 0 {
+		log.Printf("broker: %s %s compressed from %d to %d bytes, ratio %.2f", kind, p.EndpointName(), len(data),
+			len(compressed), ratio)
+	}
+	return compressed
+}
+
+// sendEvent traces and executes a publish, skipping the interceptors
+func (s *specificProviderBase) sendEvent(ctx context.Context, p rids.Pattern, payload interface{},
+	token ...[]byte) Error {
+	ctx, span := tracing.Start(ctx, "publish", p.EndpointName(), string(p.Method()), trace.SpanKindProducer)
+	defer span.End()
+
+	rErr := s.publish(ctx, p, payload, token...)
+	if rErr != nil {
+		tracing.RecordError(span, rErr.Code(), rErr.Error())
+	}
+	return rErr
+}
+
+func (s *specificProviderBase) publish(ctx context.Context, p rids.Pattern, payload interface{},
+	token ...[]byte) Error {
 	c := s.impl.NewCall(p, payload)
 	callMetaData := s.impl.NewCall(p, nil)
 	if len(token) > 0 && len(token[0]) > 0 {
@@ -122,6 +473,18 @@ func (s *specificProviderBase) Publish(p rids.Pattern, payload interface{}, toke
 		callMetaData.SetToken(token[0])
 	}
 
+	headers := HeadersFromContext(ctx)
+	if headers[HeaderIdempotencyKey] == "" && s.opts.IdempotentPublish {
+		key, err := IdempotencyKey(p, payload)
+		if err != nil {
+			return NewInvalidParamsError(err.Error())
+		}
+		headers = mergeHeaders(headers, map[string]string{HeaderIdempotencyKey: key})
+	}
+	if headers = tracing.Inject(ctx, headers); len(headers) > 0 {
+		c.SetHeaders(headers)
+	}
+
 	// When publishing on V1 calls we simply ignore all validations
 	if p.Version() > 1 {
 
@@ -140,7 +503,7 @@ func (s *specificProviderBase) Publish(p rids.Pattern, payload interface{}, toke
 			if err != nil {
 				return InternalError(err)
 			}
-			rErr := s.Request(vp, subEncoded, nil, token...)
+			rErr := s.send(ctx, vp, subEncoded, nil, token...)
 			if rErr != nil && rErr.Code() != ErrorServiceUnavailable.Code() {
 				// FIXME: Ignore Service Unavailable (compatible with V1)
 				return rErr
@@ -148,9 +511,20 @@ func (s *specificProviderBase) Publish(p rids.Pattern, payload interface{}, toke
 		}
 	}
 
-	return s.impl.PublishRaw(p.EndpointNameSpecific(), c.ToJSON())
+	data := c.ToJSON()
+	if s.opts.Compression != nil && s.opts.Compression.Events {
+		data = s.compress("event", p, data)
+	}
+	if durable, ok := s.impl.(DurableProvider); ok && p.Durable() {
+		return durable.PublishDurableRaw(p, data, headers[HeaderIdempotencyKey])
+	}
+	return s.impl.PublishRaw(p.EndpointNameSpecific(), data)
 }
 
 func (s *specificProviderBase) Reply(ep string, payload []byte) Error {
+	if result, ok := s.acks.Load(ep); ok {
+		result.(*ackResult).set(payload)
+		return nil
+	}
 	return s.impl.PublishRaw(ep, payload)
 }
@@ -1,6 +1,7 @@
 package broker
 
 import (
+	"context"
 	"time"
 
 	"github.com/spike-events/spike-broker/v2/pkg/rids"
@@ -17,8 +18,9 @@ type SpecificProvider interface {
 	// used. In this case, if the subscription resource has parameters they must be used instead of generic values
 	SubscribeRaw(sub Subscription, group string, handler ServiceHandler) (func(), Error)
 
-	// RequestRaw calls a low level subject with a []byte payload and an optional timeout expecting a payload as response
-	RequestRaw(subject string, data []byte, overrideTimeout ...time.Duration) ([]byte, Error)
+	// RequestRaw calls a low level subject with a []byte payload and an optional timeout expecting a payload as response.
+	// It must stop waiting as soon as ctx is done and use the ctx deadline, when present, instead of its own timeout
+	RequestRaw(ctx context.Context, subject string, data []byte, overrideTimeout ...time.Duration) ([]byte, Error)
 
 	// PublishRaw publishes a low-level event with a []byte payload on a subject
 	PublishRaw(subject string, data []byte) Error
@@ -26,3 +28,98 @@ type SpecificProvider interface {
 	// NewCall creates a Call to be used internally
 	NewCall(p rids.Pattern, payload interface{}) Call
 }
+
+// DefaultTimeout is how long calls are expected to be answered when the SpecificProvider does not implement
+// TimeoutProvider
+const DefaultTimeout = 30 * time.Second
+
+// TimeoutProvider is implemented by the SpecificProvider implementations with a configurable request timeout
+type TimeoutProvider interface {
+	// Timeout returns how long RequestRaw waits for a response when the context has no deadline
+	Timeout() time.Duration
+}
+
+// timeout returns the request timeout of the implementation, DefaultTimeout when it does not report one
+func (s *specificProviderBase) timeout() time.Duration {
+	if impl, ok := s.impl.(TimeoutProvider); ok {
+		return impl.Timeout()
+	}
+	return DefaultTimeout
+}
+
+// StreamRequester is implemented by the SpecificProvider implementations able to receive many replies to a request.
+// Other implementations receive streamed calls as a single reply through RequestRaw
+type StreamRequester interface {
+	// RequestStreamRaw works as RequestRaw but keeps waiting for more payloads, calling handler for each of them until
+	// it returns false. The timeout applies to the wait for each payload
+	RequestStreamRaw(ctx context.Context, subject string, data []byte, handler func([]byte) bool) Error
+}
+
+// requestStreamRaw calls handler with every reply to data, or with the single reply returned by RequestRaw when the
+// implementation is not a StreamRequester
+func (s *specificProviderBase) requestStreamRaw(ctx context.Context, subject string, data []byte,
+	handler func([]byte) bool) Error {
+	if impl, ok := s.impl.(StreamRequester); ok {
+		return impl.RequestStreamRaw(ctx, subject, data, handler)
+	}
+	reply, rErr := s.impl.RequestRaw(ctx, subject, data)
+	if rErr != nil {
+		return rErr
+	}
+	handler(reply)
+	return nil
+}
+
+// GatherRequester is implemented by the SpecificProvider implementations able to collect the replies of every
+// instance subscribed to a subject. Other implementations receive only the first reply through RequestRaw
+type GatherRequester interface {
+	// RequestAllRaw publishes data on a subject subscribed by many instances without a queue group, calling handler
+	// for each reply until it returns false or ctx is done
+	RequestAllRaw(ctx context.Context, subject string, data []byte, handler func([]byte) bool) Error
+}
+
+// requestAllRaw calls handler with the reply of every instance, or with the first one returned by RequestRaw when the
+// implementation is not a GatherRequester
+func (s *specificProviderBase) requestAllRaw(ctx context.Context, subject string, data []byte,
+	handler func([]byte) bool) Error {
+	if impl, ok := s.impl.(GatherRequester); ok {
+		return impl.RequestAllRaw(ctx, subject, data, handler)
+	}
+	reply, rErr := s.impl.RequestRaw(ctx, subject, data)
+	if rErr != nil {
+		return rErr
+	}
+	handler(reply)
+	return nil
+}
+
+// DurableProvider is implemented by the SpecificProvider implementations able to keep the events published on durable
+// Patterns until every monitoring group receives them. Other implementations publish them with PublishRaw
+type DurableProvider interface {
+	// PublishDurableRaw stores a low-level event with a []byte payload on the specific endpoint of p. Events with the
+	// same non-empty msgID must be stored once
+	PublishDurableRaw(p rids.Pattern, data []byte, msgID string) Error
+}
+
+// AckKind tells a DurableSubscriber how to settle a durable event once its handler returns
+type AckKind int
+
+const (
+	// AckDone acknowledges the event, it is not delivered again
+	AckDone AckKind = iota
+	// AckRetry delivers the event again after the delay returned along with it
+	AckRetry
+	// AckTerm stops delivering the event even though it was not handled
+	AckTerm
+)
+
+// DurableHandler handles the attempt-th delivery of a durable event, starting at 1, returning how it must be settled
+type DurableHandler func(sub Subscription, payload []byte, attempt int) (AckKind, time.Duration)
+
+// DurableSubscriber is implemented by the DurableProvider implementations able to redeliver the durable events their
+// monitors fail to handle. Monitors of durable Patterns are subscribed through it instead of SubscribeRaw
+type DurableSubscriber interface {
+	// SubscribeDurableRaw works as SubscribeRaw for a monitoring group, settling each event as handler returns. Events
+	// that are not handled, as the ones dropped by the Subscription Overflow, must be delivered again
+	SubscribeDurableRaw(sub Subscription, group string, handler DurableHandler) (func(), Error)
+}
@@ -0,0 +1,31 @@
+package broker
+
+// Status reports the health of the connections a Provider keeps to the Spike network
+type Status struct {
+	// Healthy is set when every connection is up
+	Healthy bool `json:"healthy"`
+
+	// Connections is the number of connections kept by the Provider and Connected how many of them are up
+	Connections int `json:"connections"`
+	Connected   int `json:"connected"`
+
+	// PendingBytes is the amount of data buffered to be sent, like the messages published while reconnecting
+	PendingBytes int `json:"pendingBytes"`
+
+	// LastError is the last connection error reported
+	LastError string `json:"lastError,omitempty"`
+}
+
+// StatusProvider is implemented by the SpecificProvider implementations able to report the health of their
+// connections. Other implementations are always reported healthy
+type StatusProvider interface {
+	// Status returns the current health of the connections
+	Status() Status
+}
+
+func (s *specificProviderBase) Status() Status {
+	if impl, ok := s.impl.(StatusProvider); ok {
+		return impl.Status()
+	}
+	return Status{Healthy: true}
+}
@@ -0,0 +1,278 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	spikeutils "github.com/spike-events/spike-broker/v2/pkg/spike-utils"
+)
+
+// HeaderAcceptStream is sent by RequestStream to inform the handler the caller understands chunked replies
+const HeaderAcceptStream = "acceptStream"
+
+// ErrStreamClosed is returned when sending on a Stream that has already been closed
+var ErrStreamClosed = errors.New("stream closed")
+
+// MaxStreamPending is how many chunks received ahead of a missing one RequestStream holds before giving up on the
+// stream
+var MaxStreamPending = 256
+
+// ErrorStreamOverflow is informed when the caller does not keep up with a chunked reply and chunks are lost or
+// arrive too far out of order
+var ErrorStreamOverflow = NewError("stream overflow: chunks were dropped or arrived too far out of order",
+	http.StatusInternalServerError, nil)
+
+// Stream sends a Call response in ordered chunks. Close must be called once, before the handler returns, to end it
+type Stream interface {
+	// Send encodes v the same way Call.OK does and sends it as the next chunk
+	Send(v interface{}) error
+
+	// Close ends the stream, informing err to the caller when given
+	Close(err ...error)
+}
+
+// StreamChunk is a chunk received from RequestStream. The last chunk of a stream ended with an error has Err set
+type StreamChunk struct {
+	Seq  int
+	Data RawData
+	Err  Error
+}
+
+// Decode unmarshals the chunk data into v
+func (c StreamChunk) Decode(v interface{}) error {
+	return json.Unmarshal(c.Data, v)
+}
+
+// marshalResult encodes a result as Call.OK does, sending strings and []byte as they are
+func marshalResult(result interface{}) ([]byte, error) {
+	switch result.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(result.(string)), nil
+	case []byte:
+		return result.([]byte), nil
+	default:
+		// Make sure we always marshal pointer structures
+		return json.Marshal(spikeutils.PointerFromInterface(result))
+	}
+}
+
+// bufferedStream collects the chunks and replies them at once as a JSON array, used when the caller does not
+// understand chunked replies
+type bufferedStream struct {
+	c      Call
+	m      sync.Mutex
+	chunks []json.RawMessage
+	closed bool
+}
+
+// NewBufferedStream returns a Stream that answers c with a single OK holding the JSON array of the chunks sent, or
+// with the error given to Close
+func NewBufferedStream(c Call) Stream {
+	return &bufferedStream{c: c}
+}
+
+func (s *bufferedStream) Send(v interface{}) error {
+	data, err := marshalResult(v)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(data) {
+		if data, err = json.Marshal(string(data)); err != nil {
+			return err
+		}
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	s.chunks = append(s.chunks, data)
+	return nil
+}
+
+func (s *bufferedStream) Close(err ...error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+
+	if len(err) > 0 && err[0] != nil {
+		s.c.Error(err[0])
+		return
+	}
+	if s.chunks == nil {
+		s.chunks = make([]json.RawMessage, 0)
+	}
+	s.c.OK(s.chunks)
+}
+
+// callStream sends each chunk as a marked envelope carrying its sequence number. The end marker carries the number of
+// chunks sent, so the caller knows when it has received all of them even if they arrive out of order
+type callStream struct {
+	c      *callBase
+	m      sync.Mutex
+	seq    int
+	closed bool
+}
+
+func (s *callStream) Send(v interface{}) error {
+	data, err := marshalResult(v)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	s.c.reply(encodeFrame(frameParams{"stream": strconv.Itoa(s.seq)}, data))
+	s.seq++
+	return nil
+}
+
+func (s *callStream) Close(err ...error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+
+	var body []byte
+	if len(err) > 0 && err[0] != nil {
+		var brokerErr Error
+		if !errors.As(err[0], &brokerErr) {
+			brokerErr = InternalError(err[0])
+		}
+		body = encodeReply(s.c.codec, brokerErr.Code(), brokerErr.Data(), brokerErr.Error())
+	}
+	s.c.reply(encodeFrame(frameParams{"stream": "end", "count": strconv.Itoa(s.seq)}, body))
+}
+
+// streamReader turns the raw messages of a stream into ordered chunks
+type streamReader struct {
+	ctx     context.Context
+	chunks  chan StreamChunk
+	started chan Error
+	pending map[int]RawData
+	next    int
+	total   int
+	endErr  Error
+
+	// streaming is set once the first chunk arrives, framed and codec tell how the service replied
+	streaming bool
+	framed    bool
+	codec     Codec
+}
+
+func newStreamReader(ctx context.Context) *streamReader {
+	return &streamReader{
+		ctx:     ctx,
+		chunks:  make(chan StreamChunk),
+		started: make(chan Error, 1),
+		pending: make(map[int]RawData),
+		total:   -1,
+	}
+}
+
+// handle processes a raw message, returning false once nothing else is expected
+func (r *streamReader) handle(msg []byte) bool {
+	params, body, marked := decodeFrame(msg)
+	seq, isStream := params["stream"]
+	if !marked || !isStream {
+		// The handler did not stream, its reply is delivered as a single chunk
+		reply, codec, framed := decodeReply(msg)
+		r.framed, r.codec = framed, codec
+		if reply == nil {
+			r.started <- NewError("invalid payload", http.StatusInternalServerError, msg)
+			return false
+		}
+		if math.Abs(float64(reply.Code()-http.StatusOK)) >= 100 {
+			r.started <- reply
+			return false
+		}
+		r.started <- nil
+		r.send(StreamChunk{Data: reply.Data()})
+		return false
+	}
+
+	if !r.streaming {
+		r.streaming, r.framed = true, true
+		r.started <- nil
+	}
+	body, err := decompressEnvelope(params, body)
+	if err != nil {
+		rErr, ok := err.(Error)
+		if !ok {
+			rErr = InternalError(err)
+		}
+		r.send(StreamChunk{Seq: r.next, Err: rErr})
+		return false
+	}
+
+	if seq == "end" {
+		r.total, _ = strconv.Atoi(params["count"])
+		if len(body) > 0 {
+			if r.endErr, _, _ = decodeReply(body); r.endErr == nil {
+				r.endErr = NewError("invalid payload", http.StatusInternalServerError, body)
+			}
+		}
+	} else {
+		n, err := strconv.Atoi(seq)
+		if err != nil {
+			r.send(StreamChunk{Seq: r.next, Err: InternalError(err)})
+			return false
+		}
+		if n >= r.next && len(r.pending) >= MaxStreamPending {
+			r.send(StreamChunk{Seq: r.next, Err: ErrorStreamOverflow})
+			return false
+		}
+		r.pending[n] = body
+	}
+
+	for data, ok := r.pending[r.next]; ok; data, ok = r.pending[r.next] {
+		delete(r.pending, r.next)
+		if !r.send(StreamChunk{Seq: r.next, Data: data}) {
+			return false
+		}
+		r.next++
+	}
+
+	if r.total < 0 || r.next < r.total {
+		return true
+	}
+	if r.endErr != nil {
+		r.send(StreamChunk{Seq: r.next, Err: r.endErr})
+	}
+	return false
+}
+
+// fail informs an error received while waiting for the stream messages
+func (r *streamReader) fail(err Error) {
+	if !r.streaming {
+		r.started <- err
+		return
+	}
+	r.send(StreamChunk{Seq: r.next, Err: err})
+}
+
+// send delivers a chunk unless the caller gave up on the stream
+func (r *streamReader) send(chunk StreamChunk) bool {
+	select {
+	case r.chunks <- chunk:
+		return true
+	case <-r.ctx.Done():
+		return false
+	}
+}
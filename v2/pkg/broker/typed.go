@@ -0,0 +1,52 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+)
+
+// Request calls p on provider with req as payload, decoding the response into a Resp
+func Request[Req, Resp any](provider Provider, p rids.Pattern, req Req, token ...[]byte) (Resp, Error) {
+	return RequestCtx[Req, Resp](context.Background(), provider, p, req, token...)
+}
+
+// RequestCtx works as Request but stops waiting when ctx is done
+func RequestCtx[Req, Resp any](ctx context.Context, provider Provider, p rids.Pattern, req Req,
+	token ...[]byte) (Resp, Error) {
+	var resp Resp
+	if rErr := provider.RequestCtx(ctx, p, req, &resp, token...); rErr != nil {
+		return resp, rErr
+	}
+	return resp, nil
+}
+
+// Get calls p on provider without a payload, decoding the response into a Resp
+func Get[Resp any](provider Provider, p rids.Pattern, token ...[]byte) (Resp, Error) {
+	return GetCtx[Resp](context.Background(), provider, p, token...)
+}
+
+// GetCtx works as Get but stops waiting when ctx is done
+func GetCtx[Resp any](ctx context.Context, provider Provider, p rids.Pattern, token ...[]byte) (Resp, Error) {
+	var resp Resp
+	if rErr := provider.GetCtx(ctx, p, &resp, token...); rErr != nil {
+		return resp, rErr
+	}
+	return resp, nil
+}
+
+// Handle returns a CallHandler decoding the Call payload into a Req before calling handler. Calls with an invalid
+// payload are answered with ErrorInvalidParams, an empty payload is handled as the Req zero value
+func Handle[Req any](handler func(c Call, req Req)) CallHandler {
+	return func(c Call) {
+		var req Req
+		if data := c.RawData(); len(data) > 0 {
+			if err := json.Unmarshal(data, &req); err != nil {
+				c.Error(ErrorInvalidParams)
+				return
+			}
+		}
+		handler(c, req)
+	}
+}
@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OverflowPolicy decides what happens to a message arriving when the Subscription queue is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits for room on the queue, holding the following messages on the Provider buffers
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowReject answers requests with ErrorServiceUnavailable, so callers may retry on another instance
+	OverflowReject OverflowPolicy = "reject"
+	// OverflowDrop discards the message, meant for events
+	OverflowDrop OverflowPolicy = "drop"
+)
+
+type poolMessage struct {
+	run           func()
+	replyEndpoint string
+	dropped       func()
+}
+
+// WorkerPool runs the messages received for a Subscription respecting its MaxInFlight, QueueSize and Overflow.
+// Provider implementations dispatch every message received through it
+type WorkerPool struct {
+	sub      Subscription
+	handler  ServiceHandler
+	reject   func(replyEndpoint string)
+	queue    chan poolMessage
+	wg       sync.WaitGroup
+	inFlight prometheus.Gauge
+}
+
+// NewWorkerPool starts the workers of sub. reject is called with the reply endpoint of the requests rejected by
+// OverflowReject and must answer them with ErrorServiceUnavailable. handler may be nil when only DispatchFunc is used
+func NewWorkerPool(sub Subscription, handler ServiceHandler, reject func(replyEndpoint string)) *WorkerPool {
+	w := &WorkerPool{
+		sub:      sub,
+		handler:  handler,
+		reject:   reject,
+		inFlight: handlersInFlight.WithLabelValues(sub.Resource.EndpointName()),
+	}
+	if sub.MaxInFlight <= 0 {
+		return w
+	}
+
+	w.queue = make(chan poolMessage, sub.QueueSize)
+	w.wg.Add(sub.MaxInFlight)
+	for i := 0; i < sub.MaxInFlight; i++ {
+		go func() {
+			defer w.wg.Done()
+			for msg := range w.queue {
+				w.run(msg)
+			}
+		}()
+	}
+	return w
+}
+
+// Dispatch hands a message to a worker, applying the overflow policy when all of them are busy and the queue is
+// full. Without MaxInFlight every message runs on its own goroutine
+func (w *WorkerPool) Dispatch(payload []byte, replyEndpoint string) {
+	w.dispatch(poolMessage{
+		run: func() {
+			w.handler(w.sub, payload, replyEndpoint)
+		},
+		replyEndpoint: replyEndpoint,
+	})
+}
+
+// DispatchFunc runs fn on a worker the same way Dispatch runs the handler. dropped is called instead of fn when the
+// overflow policy discards it, letting the Provider settle messages that are not handled
+func (w *WorkerPool) DispatchFunc(fn func(), dropped func()) {
+	w.dispatch(poolMessage{run: fn, dropped: dropped})
+}
+
+func (w *WorkerPool) dispatch(msg poolMessage) {
+	w.sub.track(1)
+	if w.queue == nil {
+		go w.run(msg)
+		return
+	}
+
+	switch w.sub.Overflow {
+	case OverflowReject, OverflowDrop:
+		select {
+		case w.queue <- msg:
+		default:
+			w.sub.track(-1)
+			handlerOverflows.WithLabelValues(w.sub.Resource.EndpointName(), string(w.sub.Overflow)).Inc()
+			if w.sub.Overflow == OverflowReject && msg.replyEndpoint != "" && w.reject != nil {
+				w.reject(msg.replyEndpoint)
+			}
+			if msg.dropped != nil {
+				msg.dropped()
+			}
+		}
+	default:
+		w.queue <- msg
+	}
+}
+
+// Close stops the workers once the queued messages are handled. Dispatch must not be called afterwards
+func (w *WorkerPool) Close() {
+	if w.queue != nil {
+		close(w.queue)
+		w.wg.Wait()
+	}
+}
+
+func (w *WorkerPool) run(msg poolMessage) {
+	w.inFlight.Inc()
+	defer w.inFlight.Dec()
+	defer w.sub.track(-1)
+	msg.run()
+}
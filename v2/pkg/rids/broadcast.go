@@ -0,0 +1,17 @@
+package rids
+
+// Broadcast returns a copy of p on the subject every instance subscribes without a queue group when the Subscription
+// sets Broadcast. It keeps the service, method and parameters of p
+func Broadcast(p Pattern) Pattern {
+	clone := p.Clone()
+	bp, ok := clone.(*pattern)
+	if !ok {
+		return clone
+	}
+	if bp.MethodValue.GenericEndpoint == "" {
+		bp.MethodValue.GenericEndpoint = "broadcast"
+	} else {
+		bp.MethodValue.GenericEndpoint = "broadcast." + bp.MethodValue.GenericEndpoint
+	}
+	return bp
+}
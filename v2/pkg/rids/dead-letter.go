@@ -0,0 +1,18 @@
+package rids
+
+// DeadLetter returns the Event Pattern where the events p monitors failed to handle are published, appending dlq to
+// the endpoint of p. It keeps the service, parameters and durability of p
+func DeadLetter(p Pattern) Pattern {
+	clone := p.Clone()
+	dp, ok := clone.(*pattern)
+	if !ok {
+		return clone
+	}
+	if dp.MethodValue.GenericEndpoint == "" {
+		dp.MethodValue.GenericEndpoint = "dlq"
+	} else {
+		dp.MethodValue.GenericEndpoint += ".dlq"
+	}
+	dp.MethodValue.HttpMethod = EVENT
+	return dp
+}
@@ -10,6 +10,8 @@ import (
 
 type Method interface {
 	Public() Method
+	Retry(policy RetryPolicy) Method
+	Durable() Method
 	Get() Pattern
 	Post() Pattern
 	Put() Pattern
@@ -29,6 +31,8 @@ type method struct {
 	Params          map[string]fmt.Stringer `json:"params"`
 	IsPublic        bool                    `json:"isPublic"`
 	Version         int                     `json:"version"`
+	RetryValue      *RetryPolicy            `json:"-"`
+	DurableValue    bool                    `json:"-"`
 }
 
 func (m *method) UnmarshalJSON(data []byte) error {
@@ -109,6 +113,19 @@ func (m *method) Public() Method {
 	return m
 }
 
+// Retry sets the retry policy used when requesting the Pattern, overriding the one set on the Provider
+func (m *method) Retry(policy RetryPolicy) Method {
+	m.RetryValue = &policy
+	return m
+}
+
+// Durable marks the Event Pattern as durable, keeping the events published while no monitor is subscribed on the
+// Provider implementations supporting it
+func (m *method) Durable() Method {
+	m.DurableValue = true
+	return m
+}
+
 func (m *method) Get() Pattern {
 	m.HttpMethod = "GET"
 	return newPattern(m)
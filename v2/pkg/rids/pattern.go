@@ -36,6 +36,8 @@ type Pattern interface {
 	SetParams(params map[string]fmt.Stringer)
 	Clone() Pattern
 	Version() int
+	RetryPolicy() *RetryPolicy
+	Durable() bool
 }
 
 func newPattern(m *method) Pattern {
@@ -106,6 +108,15 @@ func (p *pattern) Version() int {
 	return p.MethodValue.Version
 }
 
+func (p *pattern) RetryPolicy() *RetryPolicy {
+	return p.MethodValue.RetryValue
+}
+
+// Durable reports whether events published on the Pattern are kept until the monitoring groups receive them
+func (p *pattern) Durable() bool {
+	return p.MethodValue.DurableValue && p.MethodValue.HttpMethod == EVENT
+}
+
 func (p *pattern) Clone() Pattern {
 	mClone := *p.MethodValue
 	clone := newPattern(&mClone).(*pattern)
@@ -0,0 +1,95 @@
+package rids
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jitterRand is seeded on start, as the global source of math/rand is not seeded before go 1.20 and every instance
+// would wait the same "random" backoffs
+var (
+	jitterRand  = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterRandM sync.Mutex
+)
+
+// jitter returns a random number in [-1, 1)
+func jitter() float64 {
+	jitterRandM.Lock()
+	defer jitterRandM.Unlock()
+	return 2*jitterRand.Float64() - 1
+}
+
+// RetryPolicy configures how failed requests are retried. It only applies to idempotent methods (GET, PUT and
+// DELETE) unless AllowNonIdempotent is set
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry, defaults to 100ms
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait between attempts, defaults to 5s
+	MaxBackoff time.Duration
+
+	// Multiplier grows the backoff after each attempt, defaults to 2
+	Multiplier float64
+
+	// Jitter randomizes each backoff by up to this fraction of it, between 0 and 1
+	Jitter float64
+
+	// Codes lists the error codes that are retried, defaults to 503 (service unavailable) and 408 (timeout)
+	Codes []int
+
+	// AllowNonIdempotent enables retries on POST, PATCH and INTERNAL methods
+	AllowNonIdempotent bool
+}
+
+// Applies reports if the policy retries requests using method
+func (r *RetryPolicy) Applies(method MethodType) bool {
+	if r == nil || r.MaxAttempts < 2 {
+		return false
+	}
+	switch method {
+	case GET, PUT, DELETE:
+		return true
+	case EVENT:
+		return false
+	}
+	return r.AllowNonIdempotent
+}
+
+// Retries reports if an error with code must be retried
+func (r *RetryPolicy) Retries(code int) bool {
+	if len(r.Codes) == 0 {
+		return code == http.StatusServiceUnavailable || code == http.StatusRequestTimeout
+	}
+	for _, c := range r.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the wait before the retry following attempt, starting at 1
+func (r *RetryPolicy) Backoff(attempt int) time.Duration {
+	initial, maxBackoff, multiplier := r.InitialBackoff, r.MaxBackoff, r.Multiplier
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 5 * time.Second
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	backoff := math.Min(float64(initial)*math.Pow(multiplier, float64(attempt-1)), float64(maxBackoff))
+	if r.Jitter > 0 {
+		backoff += backoff * math.Min(r.Jitter, 1) * jitter()
+	}
+	return time.Duration(backoff)
+}
@@ -23,3 +23,11 @@ func (r *spike) EventSocketConnected() Pattern {
 func (r *spike) EventSocketDisconnected(id ...fmt.Stringer) Pattern {
 	return r.NewMethod("User has disconnected from Socket channel", "socket.disconnected.$Id", id...).Event()
 }
+
+func (r *spike) EventCircuitBreakerChanged() Pattern {
+	return r.NewMethod("Circuit breaker of an endpoint has changed state", "circuitBreaker.changed").Event()
+}
+
+func (r *spike) EventConnectionChanged() Pattern {
+	return r.NewMethod("Connection of a Provider to the network has changed state", "connection.changed").Event()
+}
@@ -3,6 +3,7 @@ package spike
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/spike-events/spike-broker/v2/pkg/broker"
@@ -18,17 +19,26 @@ type serviceImpl struct {
 	id          uuid.UUID
 	broker      broker.Provider
 	logger      service.Logger
-	monitorSubs []func()
+	tracker     *broker.Tracker
+	unsubscribe []func()
 }
 
 func (s *serviceImpl) Setup(options Options) error {
 	s.opts = &options
 	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if options.Service != nil && options.Service.Rid() != nil {
+		s.ctx = broker.WithHeaders(s.ctx, map[string]string{
+			broker.HeaderCallerService: options.Service.Rid().Name(),
+		})
+	}
 	id, err := uuid.NewV4()
 	if err != nil {
 		return err
 	}
 	s.id = id
+	// The calls made by the handlers and the Service on its context are counted, so Stop waits for them
+	s.tracker = broker.NewTracker()
+	s.ctx = broker.WithTracker(s.ctx, s.tracker)
 	s.broker = options.Service.Broker()
 	s.logger = options.Service.Logger()
 	return nil
@@ -48,6 +58,8 @@ func (s *serviceImpl) StartService() error {
 		call, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
 		if err == nil {
 			call.SetProvider(s.broker)
+			release := call.SetContext(s.ctx)
+			defer release()
 			access := broker.NewAccess(call)
 			handleRequest(sub, call, access, *s.opts)
 		}
@@ -70,13 +82,13 @@ func (s *serviceImpl) StartService() error {
 	}
 
 	for _, sub := range s.opts.Service.Handlers() {
-		if _, err := s.broker.Subscribe(sub, handler); err != nil {
+		if err := s.subscribe(sub, handler); err != nil {
 			return err
 		}
 	}
 
 	// Subscribe Live method
-	if _, err := s.broker.Subscribe(broker.Subscription{
+	if err := s.subscribe(broker.Subscription{
 		Resource: s.opts.Service.Rid().Live(),
 		Handler:  func(c broker.Call) { c.OK() },
 	}, handler); err != nil {
@@ -84,15 +96,15 @@ func (s *serviceImpl) StartService() error {
 	}
 
 	if withMonitors, ok := s.opts.Service.(service.WithMonitors); ok && withMonitors.Monitors() != nil {
-		s.monitorSubs = make([]func(), 0)
 		for group, subs := range withMonitors.Monitors() {
 			for _, sub := range subs {
 				ctxGroup := fmt.Sprintf("%s-%s", s.opts.Service.Rid().Name(), group)
-				if unsubscribe, err := s.broker.Monitor(ctxGroup, sub, handler); err == nil {
-					s.monitorSubs = append(s.monitorSubs, unsubscribe)
-				} else {
+				sub.Tracker = s.tracker
+				unsubscribe, err := s.broker.Monitor(ctxGroup, sub, handler)
+				if err != nil {
 					return err
 				}
+				s.unsubscribe = append(s.unsubscribe, unsubscribe)
 			}
 		}
 	}
@@ -116,8 +128,7 @@ func (s *serviceImpl) StartService() error {
 				call.OK()
 			}
 		}
-		_, err := s.broker.Subscribe(monitorValidateSub, eventHandlerForValidation)
-		if err != nil {
+		if err := s.subscribe(monitorValidateSub, eventHandlerForValidation); err != nil {
 			return err
 		}
 
@@ -138,8 +149,7 @@ func (s *serviceImpl) StartService() error {
 				call.OK()
 			}
 		}
-		_, err = s.broker.Subscribe(publishValidateSub, eventHandlerForValidation)
-		if err != nil {
+		if err := s.subscribe(publishValidateSub, eventHandlerForValidation); err != nil {
 			return err
 		}
 	}
@@ -150,26 +160,49 @@ func (s *serviceImpl) StartService() error {
 	return nil
 }
 
+// subscribe subscribes a handler of the Service, counting its calls on the Service Tracker
+func (s *serviceImpl) subscribe(sub broker.Subscription, handler broker.ServiceHandler) error {
+	sub.Tracker = s.tracker
+	unsubscribe, err := s.broker.Subscribe(sub, handler)
+	if err != nil {
+		return err
+	}
+	s.unsubscribe = append(s.unsubscribe, unsubscribe)
+	return nil
+}
+
 func (s *serviceImpl) Stop() error {
 	if s.opts == nil {
 		return fmt.Errorf("API not initialized")
 	}
 
-	if s.monitorSubs != nil && len(s.monitorSubs) > 0 {
-		for _, unsubscribe := range s.monitorSubs {
-			unsubscribe()
-		}
-		s.logger.Printf("stopping: unsubscribed from all monitors")
-	} else {
-		s.logger.Printf("stopping: no monitor to unsubscribe")
+	for _, unsubscribe := range s.unsubscribe {
+		unsubscribe()
+	}
+	s.unsubscribe = nil
+	s.logger.Printf("stopping: unsubscribed from all handlers and monitors")
+
+	timeout := s.opts.DrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	abandoned := s.tracker.Wait(ctx)
+	cancel()
+	s.logger.Printf("stopping: handlers drained, %d calls abandoned", abandoned)
 
 	<-s.opts.Service.Stop()
 	s.logger.Printf("stopping: service stopped, cancelling context")
 	s.cancel()
+	if abandoned > 0 {
+		return fmt.Errorf("stopping: %d calls abandoned", abandoned)
+	}
 	return nil
 }
 
+// defaultDrainTimeout is how long Stop waits for the running handlers when Options.DrainTimeout is not set
+const defaultDrainTimeout = 30 * time.Second
+
 func (s *serviceImpl) validateMonitor(access broker.Access) {
 	p, err := rids.UnmarshalPattern(access.RawData())
 	if err != nil {
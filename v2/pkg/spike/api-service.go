@@ -3,6 +3,7 @@ package spike
 import (
 	"time"
 
+	"github.com/spike-events/spike-broker/v2/pkg/broker"
 	"github.com/spike-events/spike-broker/v2/pkg/service"
 )
 
@@ -19,6 +20,14 @@ type Options struct {
 
 	// Timeout is the default timeout used internally
 	Timeout time.Duration
+
+	// DrainTimeout is how long Stop waits for the running handlers and their calls after unsubscribing them, 30
+	// seconds when zero
+	DrainTimeout time.Duration
+
+	// Middlewares wrap every handler and monitor of the Service. They run in order after the panic recovery and before
+	// authentication, so they see all Calls, including the ones that will be denied
+	Middlewares []broker.CallMiddleware
 }
 
 // APIService interface for starting and stopping the service.Service instance. It is defined as an interface to allow the
@@ -34,7 +43,9 @@ type APIService interface {
 	// StartService starts the service specified on provided Options
 	StartService() error
 
-	// Stop requests all services to close connections and HTTP server to stop in case it was stated
+	// Stop requests all services to close connections and HTTP server to stop in case it was stated. The handlers are
+	// unsubscribed and the calls they and the Service are running are waited for before the Service is stopped,
+	// failing with the number of calls abandoned after the DrainTimeout. The Broker is left open to its owner
 	Stop() error
 }
 
@@ -172,7 +172,7 @@ func (s *testServiceImpl) TestRequestOrPublish(params APITestRequestOrPublish) b
 	s.broker.SetMocks(params.Mocks)
 
 	call := testProvider.NewCall(params.Pattern, params.Payload, params.Token, params.Ok, params.Err, params.File)
-	handleRequestForTest(params.Pattern, call, *s.opts)
+	handleRequestForTest(s.ctx, params.Pattern, call, *s.opts)
 	return call.GetError()
 }
 
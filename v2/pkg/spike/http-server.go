@@ -20,7 +20,9 @@ import (
 	"github.com/spike-events/spike-broker/v2/pkg/service"
 	spikeutils "github.com/spike-events/spike-broker/v2/pkg/spike-utils"
 	"github.com/spike-events/spike-broker/v2/pkg/spike/socket"
+	"github.com/spike-events/spike-broker/v2/pkg/tracing"
 	"github.com/vincent-petithory/dataurl"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type HttpOptions struct {
@@ -46,6 +48,10 @@ type HttpOptions struct {
 
 	// Logger implements the logger interface for registering logs
 	Logger service.Logger
+
+	// Headers lists the HTTP headers forwarded as Call headers besides the request ID, client IP, user agent and locale,
+	// see socket.RequestHeaders for the limits applied
+	Headers []string
 }
 
 // HttpServer implements the server that handles REST and WebSocket requests
@@ -115,9 +121,7 @@ func (h *httpServer) ListenAndServe() error {
 			rw.WriteHeader(http.StatusOK)
 		})
 
-		h.router.HandleFunc("/health", func(rw http.ResponseWriter, r *http.Request) {
-			rw.WriteHeader(http.StatusOK)
-		})
+		h.router.HandleFunc("/health", h.healthHandler)
 
 		// Register pprof handlers
 		h.router.HandleFunc("/debug/pprof/", pprof.Index)
@@ -194,11 +198,65 @@ func (h *httpServer) httpSetup(wsPrefix string) {
 		Authenticator: h.opts.Authenticator,
 		Authorizer:    h.opts.Authorizer,
 		Logger:        h.opts.Logger,
+		Headers:       h.opts.Headers,
 	}
 	wsPrefix = strings.Replace(wsPrefix, "/", "", 1)
 	h.router.HandleFunc(fmt.Sprintf("/%s", wsPrefix), socket.NewConnectionWS(wsOpts))
 }
 
+// healthHandler answers the Broker status, with 503 when it is not healthy
+func (h *httpServer) healthHandler(w http.ResponseWriter, r *http.Request) {
+	status := h.opts.Broker.Status()
+	data, err := json.Marshal(&status)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.Write(data)
+}
+
+const ndjsonContentType = "application/x-ndjson"
+
+// streamHandler writes the response chunks as newline delimited JSON, flushing each one as it arrives. An error
+// after the first chunk is written as the last line, as the status code has already been sent
+func (h *httpServer) streamHandler(ctx context.Context, span trace.Span, p rids.Pattern, data []byte,
+	token json.RawMessage, w http.ResponseWriter) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks, rErr := h.opts.Broker.RequestStream(ctx, p, data, token)
+	if rErr != nil {
+		tracing.RecordError(span, rErr.Code(), rErr.Error())
+		w.WriteHeader(rErr.Code())
+		w.Write(rErr.ToJSON())
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	for chunk := range chunks {
+		line := []byte(chunk.Data)
+		if chunk.Err != nil {
+			tracing.RecordError(span, chunk.Err.Code(), chunk.Err.Error())
+			line = chunk.Err.ToJSON()
+		} else if !json.Valid(line) {
+			line, _ = json.Marshal(string(line))
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
 func (h *httpServer) httpHandler(p rids.Pattern, w http.ResponseWriter, r *http.Request) {
 
 	defer r.Body.Close()
@@ -227,8 +285,19 @@ func (h *httpServer) httpHandler(p rids.Pattern, w http.ResponseWriter, r *http.
 	p.SetParams(params)
 	p.Query(r.URL.Query().Encode())
 	var result broker.RawData
-	rErr := h.opts.Broker.Request(p, data, &result, token)
+	ctx, span := tracing.Start(tracing.ExtractHTTP(r.Context(), r.Header), "HTTP "+r.Method, p.EndpointName(),
+		string(p.Method()), trace.SpanKindServer)
+	defer span.End()
+
+	ctx = broker.WithHeaders(ctx, socket.RequestHeaders(r, h.opts.Headers...))
+	if strings.Contains(r.Header.Get("Accept"), ndjsonContentType) {
+		h.streamHandler(ctx, span, p, data, token, w)
+		return
+	}
+
+	rErr := h.opts.Broker.RequestCtx(ctx, p, data, &result, token)
 	if rErr != nil {
+		tracing.RecordError(span, rErr.Code(), rErr.Error())
 		w.WriteHeader(rErr.Code())
 		w.Write(rErr.ToJSON())
 		return
@@ -5,56 +5,129 @@ import (
 	"fmt"
 
 	"github.com/spike-events/spike-broker/v2/pkg/broker"
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+	"github.com/spike-events/spike-broker/v2/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// handleRequest
+// handleRequest runs the Call through the pipeline: panic recovery, Options middlewares, authentication,
+// authorization, validators, Subscription middlewares and finally the handler
 func handleRequest(sub broker.Subscription, msg broker.Call, access broker.Access, opts Options) {
 	p := sub.Resource
 
-	defer func() {
-		if r := recover(); r != nil {
-			var rErr broker.Error
-			err, ok := r.(error)
-			if !ok {
-				rErr = broker.InternalError(fmt.Errorf("request: %s: %v", p.EndpointName(), r))
-			} else {
-				rErr = broker.InternalError(err)
-			}
-			opts.Service.Logger().Printf("request: panic on handler: %s %s", p.EndpointName(),
-				p.EndpointNameSpecific())
-			opts.Service.Logger().Printf("request: panic on handler: %v", r)
-			msg.Error(rErr)
-		}
-	}()
-
-	// Authenticate and Authorize
-	if p.Method() != "INTERNAL" && !p.Public() {
-		// Test Token Authentication
-		var valid bool
-		var token json.RawMessage
-		if token, valid = opts.Authenticator.ValidateToken(msg.RawToken()); !valid {
-			msg.Error(broker.ErrorStatusUnauthorized)
-			return
-		}
+	ctx, span := tracing.Start(tracing.Extract(msg.Context(), msg.Headers()), "handle", p.EndpointName(),
+		string(p.Method()), trace.SpanKindServer)
+	defer span.End()
+	if group := sub.MonitoringGroup(); group != "" {
+		ctx = broker.WithMonitoringGroup(ctx, group)
+	}
 
-		msg.SetToken(token)
+	// Nested calls made by the handler become children of this span
+	release := msg.SetContext(ctx)
+	defer release()
 
-		// Test Route Authorization
-		if !opts.Authorizer.HasPermission(msg) {
-			msg.Error(broker.ErrorStatusForbidden)
-			return
+	pipeline := []broker.CallMiddleware{recoverMiddleware(p, opts)}
+	pipeline = append(pipeline, requestPipeline(sub, opts, authMiddleware(p, opts),
+		validatorsMiddleware(sub.Validators, access))...)
+	chainMiddlewares(sub.Handler, pipeline...)(msg)
+}
+
+// requestPipeline returns the middlewares a Call of sub runs through before its handler, in order: the Options
+// middlewares, the access checks and the Subscription middlewares
+func requestPipeline(sub broker.Subscription, opts Options, access ...broker.CallMiddleware) []broker.CallMiddleware {
+	pipeline := append([]broker.CallMiddleware{}, opts.Middlewares...)
+	pipeline = append(pipeline, access...)
+	return append(pipeline, sub.Middlewares...)
+}
+
+// chainMiddlewares returns a CallHandler running middlewares in order before handler
+func chainMiddlewares(handler broker.CallHandler, middlewares ...broker.CallMiddleware) broker.CallHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// recoverMiddleware answers the Call with an internal error when the rest of the pipeline panics
+func recoverMiddleware(p rids.Pattern, opts Options) broker.CallMiddleware {
+	return func(next broker.CallHandler) broker.CallHandler {
+		return func(msg broker.Call) {
+			defer func() {
+				if r := recover(); r != nil {
+					var rErr broker.Error
+					err, ok := r.(error)
+					if !ok {
+						rErr = broker.InternalError(fmt.Errorf("request: %s: %v", p.EndpointName(), r))
+					} else {
+						rErr = broker.InternalError(err)
+					}
+					opts.Service.Logger().Printf("request: panic on handler: %s %s", p.EndpointName(),
+						p.EndpointNameSpecific())
+					opts.Service.Logger().Printf("request: panic on handler: %v", r)
+					tracing.RecordError(trace.SpanFromContext(msg.Context()), rErr.Code(), rErr.Error())
+					msg.Error(rErr)
+				}
+			}()
+			next(msg)
 		}
 	}
+}
 
-	if len(sub.Validators) > 0 {
-		for _, validator := range sub.Validators {
-			validator(access)
-			if err := access.GetError(); err != nil {
-				msg.Error(err)
+// authMiddleware validates the token and the route permission of non-public endpoints
+func authMiddleware(p rids.Pattern, opts Options) broker.CallMiddleware {
+	return func(next broker.CallHandler) broker.CallHandler {
+		return func(msg broker.Call) {
+			if p.Method() == rids.INTERNAL || p.Public() {
+				next(msg)
 				return
 			}
+
+			span := trace.SpanFromContext(msg.Context())
+
+			// Test Token Authentication
+			var valid bool
+			var token json.RawMessage
+			_, authSpan := tracing.Tracer().Start(msg.Context(), "authenticate")
+			token, valid = opts.Authenticator.ValidateToken(msg.RawToken())
+			authSpan.End()
+			if !valid {
+				tracing.RecordError(span, broker.ErrorStatusUnauthorized.Code(), broker.ErrorStatusUnauthorized.Error())
+				msg.Error(broker.ErrorStatusUnauthorized)
+				return
+			}
+
+			msg.SetToken(token)
+
+			// Test Route Authorization
+			_, authSpan = tracing.Tracer().Start(msg.Context(), "authorize")
+			valid = opts.Authorizer.HasPermission(msg)
+			authSpan.End()
+			if !valid {
+				tracing.RecordError(span, broker.ErrorStatusForbidden.Code(), broker.ErrorStatusForbidden.Error())
+				msg.Error(broker.ErrorStatusForbidden)
+				return
+			}
+
+			next(msg)
 		}
 	}
+}
 
-	sub.Handler(msg)
+// validatorsMiddleware runs the Subscription validators stopping on the first error
+func validatorsMiddleware(validators []broker.AccessHandler, access broker.Access) broker.CallMiddleware {
+	return func(next broker.CallHandler) broker.CallHandler {
+		return func(msg broker.Call) {
+			for i, validator := range validators {
+				_, validatorSpan := tracing.Tracer().Start(msg.Context(), fmt.Sprintf("validator %d", i))
+				validator(access)
+				validatorSpan.End()
+				if err := access.GetError(); err != nil {
+					tracing.RecordError(trace.SpanFromContext(msg.Context()), err.Code(), err.Error())
+					msg.Error(err)
+					return
+				}
+			}
+			next(msg)
+		}
+	}
 }
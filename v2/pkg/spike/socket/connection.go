@@ -3,6 +3,7 @@ package socket
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"sync"
 
 	"github.com/gofrs/uuid/v5"
@@ -22,6 +23,10 @@ type Options struct {
 	Authenticator service.Authenticator
 	Authorizer    service.Authorizer
 	Logger        service.Logger
+
+	// Headers lists the HTTP headers of the upgrade request forwarded on every Call made by the session, see
+	// RequestHeaders for the limits applied
+	Headers []string
 }
 
 type WSConnection interface {
@@ -39,6 +44,9 @@ type WSConnection interface {
 	SetSessionToken(token broker.RawData)
 	SetSessionID(id string)
 
+	// Headers returns the Call headers taken from the upgrade request plus the current session ID
+	Headers() map[string]string
+
 	// WriteJSON call WS connection write with locked context
 	WriteJSON(data interface{}) error
 
@@ -61,6 +69,7 @@ type wsConnection struct {
 	handlers      []rids.Pattern
 	logger        service.Logger
 	token         broker.RawData
+	headers       map[string]string
 }
 
 func (ws *wsConnection) WriteJSON(data interface{}) error {
@@ -144,7 +153,17 @@ func (ws *wsConnection) SetSessionToken(token broker.RawData) {
 func (ws *wsConnection) SetSessionID(id string) {
 	ws.ID = id
 }
-func newConnection(conn *websocket.Conn, options Options) WSConnection {
+
+func (ws *wsConnection) Headers() map[string]string {
+	headers := make(map[string]string, len(ws.headers)+1)
+	for key, value := range ws.headers {
+		headers[key] = value
+	}
+	headers[broker.HeaderSessionID] = ws.ID
+	return headers
+}
+
+func newConnection(conn *websocket.Conn, r *http.Request, options Options) WSConnection {
 	id, _ := uuid.NewV4()
 	inCtx, cancel := context.WithCancel(context.Background())
 	return &wsConnection{
@@ -157,5 +176,6 @@ func newConnection(conn *websocket.Conn, options Options) WSConnection {
 		authorizer:    options.Authorizer,
 		handlers:      options.Handlers,
 		logger:        options.Logger,
+		headers:       RequestHeaders(r, options.Headers...),
 	}
 }
@@ -32,6 +32,10 @@ type WSMessage struct {
 	Token    string        `json:"token,omitempty"`
 	Query    string        `json:"query,omitempty"`
 	Data     interface{}   `json:"data,omitempty"`
+
+	// Stream asks a request to be answered with one response per chunk, the last response has End set and no data
+	Stream bool `json:"stream,omitempty"`
+	End    bool `json:"end,omitempty"`
 }
 
 func (w *WSMessage) SpecificEndpoint() string {
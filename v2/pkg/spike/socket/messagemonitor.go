@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 
 	"github.com/spike-events/spike-broker/v2/pkg/broker"
+	"github.com/spike-events/spike-broker/v2/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type WSMessageMonitor struct {
@@ -11,7 +13,17 @@ type WSMessageMonitor struct {
 }
 
 func (m *WSMessageMonitor) Handle(ws WSConnection) broker.Error {
+	_, span := tracing.Start(ws.Context(), "WS monitor", m.SpecificEndpoint(), "", trace.SpanKindServer)
+	defer span.End()
 
+	rErr := m.handle(ws)
+	if rErr != nil {
+		tracing.RecordError(span, rErr.Code(), rErr.Error())
+	}
+	return rErr
+}
+
+func (m *WSMessageMonitor) handle(ws WSConnection) broker.Error {
 	if len(ws.GetToken()) != 0 {
 		token, valid := ws.Authenticator().ValidateToken(ws.GetToken())
 		if !valid {
@@ -48,9 +60,11 @@ func (m *WSMessageMonitor) Handle(ws WSConnection) broker.Error {
 		c.OK()
 	}
 
+	// Durable events are not kept for the session once it closes
 	sub := broker.Subscription{
-		Resource: p,
-		Handler:  localHandler,
+		Resource:  p,
+		Handler:   localHandler,
+		Ephemeral: true,
 	}
 	unsubscribe, rErr := ws.Broker().Monitor(ws.GetID().String(), sub,
 		func(sub broker.Subscription, payload []byte, replyEndpoint string) {
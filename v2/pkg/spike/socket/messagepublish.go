@@ -29,7 +29,7 @@ func (m *WSMessagePublish) Handle(ws WSConnection) broker.Error {
 		return broker.ErrorStatusForbidden
 	}
 
-	err := ws.Broker().Publish(p, m.Data, ws.GetToken())
+	err := ws.Broker().PublishCtx(broker.WithHeaders(ws.Context(), ws.Headers()), p, m.Data, ws.GetToken())
 	if err != nil {
 		return broker.InternalError(err)
 	}
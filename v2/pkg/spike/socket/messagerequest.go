@@ -1,9 +1,13 @@
 package socket
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/spike-events/spike-broker/v2/pkg/broker"
+	"github.com/spike-events/spike-broker/v2/pkg/rids"
+	"github.com/spike-events/spike-broker/v2/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type WSMessageRequest struct {
@@ -11,6 +15,17 @@ type WSMessageRequest struct {
 }
 
 func (m *WSMessageRequest) Handle(ws WSConnection) broker.Error {
+	ctx, span := tracing.Start(ws.Context(), "WS request", m.SpecificEndpoint(), "", trace.SpanKindServer)
+	defer span.End()
+
+	rErr := m.handle(ctx, ws)
+	if rErr != nil {
+		tracing.RecordError(span, rErr.Code(), rErr.Error())
+	}
+	return rErr
+}
+
+func (m *WSMessageRequest) handle(ctx context.Context, ws WSConnection) broker.Error {
 	if len(ws.GetToken()) != 0 {
 		token, valid := ws.Authenticator().ValidateToken(ws.GetToken())
 		if !valid {
@@ -31,8 +46,13 @@ func (m *WSMessageRequest) Handle(ws WSConnection) broker.Error {
 		return broker.ErrorStatusForbidden
 	}
 
+	ctx = broker.WithHeaders(ctx, ws.Headers())
+	if m.Stream {
+		return m.stream(ctx, ws, p)
+	}
+
 	var response broker.RawData
-	rErr := ws.Broker().Request(p, m.Data, &response, ws.GetToken())
+	rErr := ws.Broker().RequestCtx(ctx, p, m.Data, &response, ws.GetToken())
 	if rErr != nil {
 		return rErr
 	}
@@ -45,3 +65,36 @@ func (m *WSMessageRequest) Handle(ws WSConnection) broker.Error {
 	}
 	return nil
 }
+
+// stream writes a response frame for each chunk followed by the end frame. An error ends the stream with an error
+// frame instead
+func (m *WSMessageRequest) stream(ctx context.Context, ws WSConnection, p rids.Pattern) broker.Error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks, rErr := ws.Broker().RequestStream(ctx, p, m.Data, ws.GetToken())
+	if rErr != nil {
+		return rErr
+	}
+
+	m.Type = WSMessageTypeResponse
+	for chunk := range chunks {
+		if chunk.Err != nil {
+			return chunk.Err
+		}
+		m.Data = json.RawMessage(chunk.Data)
+		if !json.Valid(chunk.Data) {
+			m.Data = string(chunk.Data)
+		}
+		if err := ws.WriteJSON(m); err != nil {
+			return broker.InternalError(err)
+		}
+	}
+
+	m.Data = nil
+	m.End = true
+	if err := ws.WriteJSON(m); err != nil {
+		return broker.InternalError(err)
+	}
+	return nil
+}
@@ -4,14 +4,21 @@ import (
 	"fmt"
 
 	"github.com/spike-events/spike-broker/v2/pkg/broker"
+	"github.com/spike-events/spike-broker/v2/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func handleWSEvent(ws WSConnection, sub broker.Subscription, payload []byte, replyEndpoint string) {
 	msg, err := broker.NewCallFromJSON(payload, sub.Resource, replyEndpoint)
 	if err == nil {
 		msg.SetProvider(ws.Broker())
-		access := broker.NewAccess(msg)
 		p := sub.Resource
+		ctx, span := tracing.Start(tracing.Extract(ws.Context(), msg.Headers()), "WS event", p.EndpointName(),
+			string(p.Method()), trace.SpanKindConsumer)
+		defer span.End()
+		release := msg.SetContext(broker.WithHeaders(ctx, ws.Headers()))
+		defer release()
+		access := broker.NewAccess(msg)
 
 		defer func() {
 			if r := recover(); r != nil {
@@ -2,8 +2,11 @@ package socket
 
 import (
 	"fmt"
+	"net"
+	"net/http"
 	"strings"
 
+	"github.com/go-chi/chi/middleware"
 	"github.com/spike-events/spike-broker/v2/pkg/broker"
 	"github.com/spike-events/spike-broker/v2/pkg/rids"
 	spikeutils "github.com/spike-events/spike-broker/v2/pkg/spike-utils"
@@ -49,3 +52,68 @@ func PatternFromEndpoint(patterns []rids.Pattern, specificEndpoint string) (rids
 	}
 	return nil, broker.NewServiceUnavailableError(specificEndpoint)
 }
+
+const (
+	// MaxForwardedHeaders is how many HTTP headers of the forward list are copied to a Call at most
+	MaxForwardedHeaders = 16
+
+	// MaxHeaderValueLength is the longest HTTP header value copied to a Call, longer values are skipped
+	MaxHeaderValueLength = 512
+)
+
+// reservedHeaders are the Call headers set by Spike, they are never copied from the HTTP request
+var reservedHeaders = map[string]bool{
+	broker.HeaderRequestID:         true,
+	broker.HeaderClientIP:          true,
+	broker.HeaderUserAgent:         true,
+	broker.HeaderLocale:            true,
+	broker.HeaderSessionID:         true,
+	broker.HeaderCallerService:     true,
+	broker.HeaderIdempotencyKey:    true,
+	broker.HeaderAcceptCodec:       true,
+	broker.HeaderAcceptCompression: true,
+	broker.HeaderAcceptControl:     true,
+	broker.HeaderAcceptStream:      true,
+}
+
+// reserved reports if name is one of the reservedHeaders. HTTP header names are case-insensitive
+func reserved(name string) bool {
+	for header := range reservedHeaders {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestHeaders returns the Call headers extracted from an HTTP request: request ID, client IP, user agent, locale
+// and the forward list of HTTP headers, kept under their own names. Only the first MaxForwardedHeaders names of the
+// forward list are copied, reserved names are ignored and values longer than MaxHeaderValueLength are skipped
+func RequestHeaders(r *http.Request, forward ...string) map[string]string {
+	headers := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" && len(value) <= MaxHeaderValueLength {
+			headers[name] = value
+		}
+	}
+
+	set(broker.HeaderRequestID, middleware.GetReqID(r.Context()))
+
+	clientIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	set(broker.HeaderClientIP, clientIP)
+	set(broker.HeaderUserAgent, r.UserAgent())
+	set(broker.HeaderLocale, r.Header.Get("Accept-Language"))
+
+	if len(forward) > MaxForwardedHeaders {
+		forward = forward[:MaxForwardedHeaders]
+	}
+	for _, name := range forward {
+		if !reserved(name) {
+			set(name, r.Header.Get(name))
+		}
+	}
+	return headers
+}
@@ -38,7 +38,7 @@ func NewConnectionWS(options Options) func(w http.ResponseWriter, r *http.Reques
 			log.Printf("upgrade: %v", err)
 			return
 		}
-		conn := newConnection(c, options)
+		conn := newConnection(c, r, options)
 		go wsHandler(ctx, conn)
 	}
 }
@@ -1,11 +1,14 @@
 package spike
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
 	"github.com/spike-events/spike-broker/v2/pkg/broker"
 	"github.com/spike-events/spike-broker/v2/pkg/rids"
+	"github.com/spike-events/spike-broker/v2/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func handleAccessForTest(p rids.Pattern, msg broker.Call, access broker.Access, handleOk func(), handleErr func(int, interface{}), opts Options) {
@@ -38,39 +41,53 @@ func handleAccessForTest(p rids.Pattern, msg broker.Call, access broker.Access,
 		return
 	}
 
-	// Authenticate and Authorize
-	if !p.Public() {
-		// Test Token Authentication
-		var valid bool
-		var token json.RawMessage
-		if token, valid = opts.Authenticator.ValidateToken(access.RawToken()); !valid {
-			handleErr(-1, broker.ErrorStatusUnauthorized)
-			return
-		}
+	// The access checks run in the same place of the pipeline as on handleRequest, the Subscription middlewares
+	// belong to the handler and run on handleRequestForTest
+	sub := handler
+	sub.Middlewares = nil
+	pipeline := requestPipeline(sub, opts, accessMiddlewareForTest(p, access, handler.Validators, handleErr, opts))
+	chainMiddlewares(func(broker.Call) { handleOk() }, pipeline...)(msg)
+}
 
-		msg.SetToken(token)
+// accessMiddlewareForTest authenticates, authorizes and validates the Call as handleRequest does, reporting the
+// failures to handleErr
+func accessMiddlewareForTest(p rids.Pattern, access broker.Access, validators []broker.AccessHandler,
+	handleErr func(int, interface{}), opts Options) broker.CallMiddleware {
+	return func(next broker.CallHandler) broker.CallHandler {
+		return func(msg broker.Call) {
+			// Authenticate and Authorize
+			if !p.Public() {
+				// Test Token Authentication
+				var valid bool
+				var token json.RawMessage
+				if token, valid = opts.Authenticator.ValidateToken(access.RawToken()); !valid {
+					handleErr(-1, broker.ErrorStatusUnauthorized)
+					return
+				}
 
-		// Test Route Authorization
-		if !opts.Authorizer.HasPermission(msg) {
-			handleErr(-2, broker.ErrorStatusForbidden)
-			return
-		}
-	}
+				msg.SetToken(token)
+
+				// Test Route Authorization
+				if !opts.Authorizer.HasPermission(msg) {
+					handleErr(-2, broker.ErrorStatusForbidden)
+					return
+				}
+			}
 
-	if len(handler.Validators) > 0 {
-		for i, validator := range handler.Validators {
-			validator(access)
-			if err := access.GetError(); err != nil {
-				handleErr(i, err)
-				return
+			for i, validator := range validators {
+				validator(access)
+				if err := access.GetError(); err != nil {
+					handleErr(i, err)
+					return
+				}
 			}
+
+			next(msg)
 		}
 	}
-
-	handleOk()
 }
 
-func handleRequestForTest(p rids.Pattern, msg broker.Call, opts Options) {
+func handleRequestForTest(ctx context.Context, p rids.Pattern, msg broker.Call, opts Options) {
 	defer func() {
 		if r := recover(); r != nil {
 			var rErr broker.Error
@@ -100,5 +117,13 @@ func handleRequestForTest(p rids.Pattern, msg broker.Call, opts Options) {
 		return
 	}
 
-	handler.Handler(msg)
+	ctx, span := tracing.Start(tracing.Extract(ctx, msg.Headers()), "handle", p.EndpointName(),
+		string(p.Method()), trace.SpanKindServer)
+	defer span.End()
+
+	release := msg.SetContext(ctx)
+	defer release()
+
+	// The access checks of the pipeline are tested by handleAccessForTest
+	chainMiddlewares(handler.Handler, requestPipeline(handler, opts)...)(msg)
 }
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName identifies the spans created by Spike
+const InstrumentationName = "github.com/spike-events/spike-broker/v2"
+
+// Span attributes set by Spike
+const (
+	AttributeEndpoint = "spike.endpoint"
+	AttributeMethod   = "spike.method"
+	AttributeCode     = "spike.code"
+)
+
+// Exporter receives the spans finished by Spike. Any OpenTelemetry SpanExporter can be used, the tracingtest package
+// provides exporters for development and tests
+type Exporter = sdktrace.SpanExporter
+
+// propagator carries the trace context on Call headers. It does not depend on the global propagator so the trace is
+// never broken between services, even when the application did not configure OpenTelemetry
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Setup registers a global TracerProvider sending spans to exporter. Call Shutdown on the returned provider to flush
+// pending spans before the application exits
+func Setup(exporter Exporter) *sdktrace.TracerProvider {
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider
+}
+
+// Tracer returns the tracer used by Spike, taken from the global TracerProvider
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// Inject writes the trace context of ctx into headers, allocating the map when needed
+func Inject(ctx context.Context, headers map[string]string) map[string]string {
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	propagator.Inject(ctx, propagation.MapCarrier(headers))
+	return headers
+}
+
+// Extract returns a copy of ctx carrying the trace context found in headers
+func Extract(ctx context.Context, headers map[string]string) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(headers))
+}
+
+// ExtractHTTP returns a copy of ctx carrying the trace context found in HTTP headers
+func ExtractHTTP(ctx context.Context, headers http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(headers))
+}
+
+// Start creates a span named after the operation and the endpoint it handles
+func Start(ctx context.Context, operation, endpoint, method string, kind trace.SpanKind) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, operation+" "+endpoint,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(
+			attribute.String(AttributeEndpoint, endpoint),
+			attribute.String(AttributeMethod, method),
+		))
+}
+
+// RecordError marks span as failed with the error code and message
+func RecordError(span trace.Span, code int, message string) {
+	span.SetAttributes(attribute.Int(AttributeCode, code))
+	span.SetStatus(codes.Error, message)
+}
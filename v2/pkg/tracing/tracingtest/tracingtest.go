@@ -0,0 +1,21 @@
+// Package tracingtest provides the tracing.Exporter implementations meant for development and tests, so the
+// applications using the tracing package do not depend on them
+package tracingtest
+
+import (
+	"io"
+
+	"github.com/spike-events/spike-broker/v2/pkg/tracing"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// NewStdoutExporter returns an Exporter writing human-readable spans to w
+func NewStdoutExporter(w io.Writer) (tracing.Exporter, error) {
+	return stdouttrace.New(stdouttrace.WithWriter(w), stdouttrace.WithPrettyPrint())
+}
+
+// NewInMemoryExporter returns an Exporter keeping the spans in memory, to be inspected on tests
+func NewInMemoryExporter() *tracetest.InMemoryExporter {
+	return tracetest.NewInMemoryExporter()
+}